@@ -0,0 +1,357 @@
+// Package app implements the gosh command, which is started by package main
+// with the configuration on the host file system, or by an executable created
+// with "gosh build" with the configuration embedded in it.
+package app
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ardnew/gosh/cmd/gosh/cli"
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/exit"
+	"github.com/ardnew/gosh/cmd/gosh/log"
+	"github.com/ardnew/gosh/cmd/gosh/proc"
+	"github.com/ardnew/gosh/cmd/gosh/sandbox"
+
+	"github.com/joho/godotenv"
+
+	"github.com/ardnew/version"
+)
+
+func init() {
+	version.ChangeLog = []version.Change{
+		{ // initializing project version number in ONE location is fine I guess
+			Package: "gosh",
+			Version: "0.1.0",
+			Date:    "June 30, 2020",
+			Description: []string{
+				`+ Initial commit`,
+			},
+		},
+		{
+			Package: "gosh",
+			Version: "0.2.0",
+			Date:    "July 17, 2020",
+			Description: []string{
+				`% Move profile selection to flags, args represent command to run`,
+			},
+		},
+		{
+			Package: "gosh",
+			Version: "0.3.0",
+			Date:    "February 23, 2021",
+			Description: []string{
+				`+ Add option to print generated init file instead of launching shell`,
+				`+ Append shell environment with GOSH_INIT, containing path to init file`,
+				`% Default to standard log handler if debug flag provided`,
+			},
+		},
+		{
+			Package: "gosh",
+			Version: "0.3.1",
+			Date:    "March 6, 2021",
+			Description: []string{
+				`- Move go.mod file to root package path github.com/ardnew/gosh`,
+				`% Rename changelog flag from -a to -V`,
+			},
+		},
+		{
+			Package: "gosh",
+			Version: "0.4.0",
+			Date:    "April 19, 2022",
+			Description: []string{
+				`% Major refactor of configuration YAML format:`,
+				`|  + Per-profile initial working directory and env definitions`,
+				`|  + (Placeholder stub for profile inheritance)`,
+				`|  + Rename GOSH_INIT to GOSH_RCFILE`,
+				`|  + Support for multiple shell definitions`,
+				`+ Override which shell is executed with flag -e`,
+				`% Proper handling of args in non-interactive shells with flag -c`,
+			},
+		},
+		{
+			Package: "gosh",
+			Version: "0.4.1",
+			Date:    "April 20, 2022",
+			Description: []string{
+				`+ Add support for login/interactive/command shells`,
+				`+ Add handling of end-of-options delimiter "--"`,
+			},
+		},
+		{
+			Package: "gosh",
+			Version: "0.4.2",
+			Date:    "May 10, 2023",
+			Description: []string{
+				`+ Add support for logging debug output to file/stdout/stderr`,
+				`+ Add support for specifying overwrite or append with flag -w`,
+				`% Debug logging can now also be enabled via environment variable`,
+				`|  + Variable identifier is GOSH_DEBUG`,
+				`|  + Value has format "[handler,]writer"`,
+				`|  + "writer" accepts the same values as flag -w`,
+				`|  + "handler" (optional) accepts the same values as flag -o`,
+			},
+		},
+		{
+			Package: "gosh",
+			Version: "0.4.3",
+			Date:    "May 18, 2023",
+			Description: []string{
+				`+ Support preloading an environment file for initialization`,
+			},
+		},
+		{
+			Package: "gosh",
+			Version: "0.5.0",
+			Date:    "October 19, 2026",
+			Description: []string{
+				`+ Add per-profile dotenv files via profile key "envfile"`,
+				`+ Add secret env values from commands or files via profile key "secret"`,
+				`+ Add configurable redaction of sensitive env values via key "redact"`,
+				`+ Add per-profile shell history isolation via profile key "history"`,
+				`+ Add per-profile umask, nice, ionice, rlimits, locale via key "process"`,
+				`+ Add per-profile Linux namespace sandbox via profile key "sandbox"`,
+				`+ Add pre-launch and post-exit hooks to profiles and shells via key "hooks"`,
+				`% Exit with the shell's exit status (or 128+signal if it was killed)`,
+				`% Exit with status 120-125 when gosh itself fails, distinct from any shell status`,
+				`+ Forward signals to the shell and run it in its own process group`,
+				`+ Add flag "-t" to run the shell under a pseudo-terminal owned by gosh`,
+				`+ Add asciinema session recording via flag "-R" or profile key "record"`,
+				`+ Log each session and add command "history" to query and relaunch them`,
+				`+ Add flag "--profile-startup" to report the time spent in each include`,
+				`+ Mark each include in goshrc and add command "locate" to map its lines`,
+				`+ Add key "assemble" to source include files instead of copying them`,
+				`+ Add command "check" and strict mode via flag "-strict" or key "strict"`,
+				`+ Load only selected and inherited profiles, streaming includes into goshrc`,
+				`+ Cache each goshrc by content hash and remove stale goshrc files`,
+				`+ Add command "diff" to compare the environment of profiles`,
+				`+ Add command "unload" to reverse the changes of a profile in place`,
+				`+ Add commands "activate" and "reload" to load profiles in place`,
+				`% Track nested sessions in GOSH_STACK, limit depth, add command "whoami"`,
+				`+ Add flag "--explain" to print the execution plan without starting a shell`,
+				`+ Add command "bundle" to export profiles as a standalone script or archive`,
+				`+ Add command "build" to build an executable with the configuration embedded`,
+				`+ Add profile key "tmux" and command "tmux" to create tmux session layouts`,
+			},
+		},
+	}
+}
+
+// Main parses the command-line arguments and runs gosh, and does not return.
+//
+// If fsys is non-nil, it contains the configuration file and profile
+// directories used unless another configuration file is given with flag -f.
+func Main(fsys fs.FS) {
+
+	// Initialize the sandbox and execute the shell if we were started from within
+	// a new sandbox (see: sandbox.(*Spec).Command).
+	if sandbox.IsInit() {
+		exit.SandboxNotCreated.HaltAnnotated(sandbox.Init(), "sandbox not created")
+	}
+	// Apply the process attributes and execute the shell if we were started to
+	// apply them (see: proc.(*Attr).Command).
+	if proc.IsInit() {
+		exit.ShellNotCreated.HaltAnnotated(proc.Init(), "process attributes not applied")
+	}
+
+	appProp := config.AppProperties{
+		PackageName:    "gosh",
+		FileEnvName:    "env",
+		EnvDebugName:   "GOSH_DEBUG",
+		EnvDebugDelim:  ",",
+		EnvConfigName:  "GOSH_CONFIG",
+		FileConfigName: "config.yml",
+		ReqShellName:   "auto",
+		ReqProfileName: "auto",
+		PermConfigFile: 0o600,
+		PermConfigDir:  0o700,
+		PermLogFile:    0o600,
+	}
+
+	godotenv.Load(appProp.SourceEnvPath())
+
+	// the embedded configuration file is named as if it were in a directory
+	// named for the executable.
+	configPath := appProp.ConfigPath()
+	if fsys != nil {
+		if exe, err := os.Executable(); err == nil {
+			configPath = filepath.Join(exe, appProp.FileConfigName)
+		} else {
+			configPath = filepath.Join(appProp.PackageName, appProp.FileConfigName)
+		}
+	}
+
+	var debugLog string
+	var debugImplied bool
+
+	defHand := log.LogDefaultIdent.String()
+	defPath := ""
+
+	config.DebugLogHandler = "standard"
+	config.DebugLogPath = "-"
+
+	// Perform the debug log processing for the "config" package, because it
+	// cannot import our "log" package (circular imports).
+	if debugLog, debugImplied = os.LookupEnv(appProp.EnvDebugName); debugImplied {
+		logHand := config.DebugLogHandler
+		logPath := config.DebugLogPath
+		lhs, rhs, isDelimited := strings.Cut(debugLog, appProp.EnvDebugDelim)
+		if isDelimited {
+			if hid, ok := log.ParseIdent(lhs); ok {
+				logHand = hid.String()
+			}
+			if rhs != "" {
+				logPath = rhs
+			}
+		} else {
+			if hid, ok := log.ParseIdent(lhs); ok {
+				logHand = hid.String()
+				logPath = config.DebugLogPath
+			} else if lhs != "" {
+				logPath = lhs
+			}
+		}
+		config.DebugLogHandler = logHand
+		config.DebugLogPath = logPath
+	}
+
+	if debugImplied {
+		defHand = config.DebugLogHandler
+		defPath = config.DebugLogPath
+	}
+
+	appFlag := config.StartFlags{
+		Version: config.BoolFlag{
+			Flag:   "v",
+			Desc:   "Print application version.",
+			Preset: false,
+		},
+		ChangeLog: config.BoolFlag{
+			Flag:   "V",
+			Desc:   "Print the application changelog.",
+			Preset: false,
+		},
+		ConfigPath: config.StringFlag{
+			Flag:   "f",
+			Desc:   "Use an alternate configuration file located at `path`. Profile paths are relative to this configuration file.",
+			Preset: configPath,
+		},
+		Shell: config.StringFlag{
+			Flag:   "e",
+			Desc:   "Use executable and paramter templates named `shell` in configuration file.",
+			Preset: appProp.ReqShellName,
+		},
+		LogHandler: config.StringFlag{
+			Flag:   "o",
+			Desc:   fmt.Sprintf("Specify the output log `format` [%s].", strings.Join(log.IdentNames(), ", ")),
+			Preset: defHand,
+		},
+		LogPath: config.StringFlag{
+			Flag:   "w",
+			Desc:   "Write all log messages to file `path`. Use \"-\" for stdout or \"+\" for stderr. If path is prefixed with \">>\", messages will be appended to the given file. Otherwise, or if prefixed with \">\", the log file is overwritten.",
+			Preset: defPath,
+		},
+		DebugEnabled: config.BoolFlag{
+			Flag:   "g",
+			Desc:   fmt.Sprintf("Enable debug message logging (implies [-o %q] if not provided).", config.DebugLogHandler),
+			Preset: debugImplied,
+		},
+		// reversed logic for inherit because I suspect inheriting is the preferred
+		// or typical behavior. thus, user adds the flag for atypical behavior.
+		OrphanEnviron: config.BoolFlag{
+			Flag:   "u",
+			Desc:   "Do NOT inherit the environment from current process; or, if generating an init file, do NOT export the current environment.",
+			Preset: false,
+		},
+		GenerateGoshrc: config.BoolFlag{
+			Flag:   "d",
+			Desc:   "Print the generated goshrc file instead of using it to start a new shell.",
+			Preset: false,
+		},
+    AddToProfiles: config.ProfileAddFlag{
+      Flag: "A",
+      Desc: "Add file `name` to each of the profiles selected via \"-p profile\" (or \"auto\" if no profiles selected). Use a comma \",\" delimiter to add multiple files or pass each file as a separate flag.",
+    },
+		Profiles: config.ProfileFlag{
+			Flag: "p",
+			Desc: "Load files defined in configuration `profile`; may be specified multiple times.",
+		},
+		ShellCommand: config.StringFlag{
+			Flag:   "c",
+			Desc:   "Run `command` directly in a shell; use the \"commandline\" flags defined in configuration file.",
+			Preset: "",
+		},
+		LoginShell: config.BoolFlag{
+			Flag:   "l",
+			Desc:   "Behave as a login shell; use the \"loginshell\" flags defined in configuration file.",
+			Preset: false,
+		},
+		Interactive: config.BoolFlag{
+			Flag:   "i",
+			Desc:   "Behave as an interactive shell; use the \"interactive\" flags defined in configuration file.",
+			Preset: true,
+		},
+		PseudoTerminal: config.BoolFlag{
+			Flag:   "t",
+			Desc:   "Run the shell under a pseudo-terminal owned by gosh instead of the current terminal.",
+			Preset: false,
+		},
+		RecordPath: config.StringFlag{
+			Flag:   "R",
+			Desc:   "Record the session to asciinema file `path` (implies -t).",
+			Preset: "",
+		},
+		ProfileStartup: config.StartupFlag{
+			Flag: "profile-startup",
+			Desc: "Print the time spent sourcing each include file instead of starting a new shell. Use \"--profile-startup=`format`\" to select a report format [text, json, trace].",
+		},
+		Explain: config.ExplainFlag{
+			Flag: "explain",
+			Desc: "Print the shell, arguments, working directory, environment, and profiles that would be used instead of starting a new shell. Use \"--explain=`format`\" to select an output format [text, json].",
+		},
+		Strict: config.BoolFlag{
+			Flag:   "strict",
+			Desc:   "Do NOT start the shell if any include or env file of a loaded profile cannot be read.",
+			Preset: false,
+		},
+		Command: cli.Commands(),
+	}
+
+	if param, parsed, err := appFlag.Parse(&appProp); !parsed {
+		exit.FlagsNotParsed.HaltAnnotated(nil, "flags not parsed")
+	} else if err != nil {
+		exit.InvalidFlags.HaltAnnotated(err, "invalid flag(s)")
+	} else if param.ChangeLog {
+		version.PrintChangeLog()
+	} else if param.Version {
+		fmt.Println(appProp.PackageName, "version", version.String())
+	} else if ui, err := cli.Start(embed(param, fsys, configPath)); err != nil {
+		exit.CLINotStarted.HaltAnnotated(err, "CLI not started")
+	} else if param.Command != "" {
+		if status, err := ui.RunCommand(); err != nil {
+			exit.CommandFailed.HaltAnnotated(err, param.Command+" failed")
+		} else if status != 0 {
+			exit.Propagate(status)
+		}
+	} else if status, err := ui.CreateShell(); err != nil {
+		exit.ShellNotCreated.HaltAnnotated(err, "shell not created")
+	} else if status != 0 {
+		// exit with the same status as the shell, so that gosh can be used in
+		// place of the shell itself in scripts.
+		exit.Propagate(status)
+	}
+	exit.OK.Halt()
+}
+
+// embed returns the given parameters using the embedded configuration fsys if
+// the configuration file was not given with flag -f.
+func embed(param *config.Parameters, fsys fs.FS, configPath string) *config.Parameters {
+	if fsys != nil && param.ConfigPath == configPath {
+		param.ConfigFS = fsys
+	}
+	return param
+}
@@ -0,0 +1,252 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/environ"
+	"github.com/ardnew/gosh/cmd/gosh/shell"
+	"github.com/juju/errors"
+)
+
+var activateCommand = &command{
+	Command: config.Command{
+		Name: "activate",
+		Desc: "Print the code that loads the profiles selected with -p into the current shell, skipping any already loaded (e.g., eval \"$(gosh activate -p tinygo)\").",
+	},
+	Run: func(ui *CLI) (int, error) {
+		sh, ok := ui.Config.Shell[ui.Param.Shell]
+		if !ok {
+			return 0, errors.Errorf("undefined shell: %s", ui.Param.Shell)
+		}
+		if len(ui.Param.Profiles) == 0 {
+			return 0, errors.New("no profiles selected (use -p profile)")
+		}
+		return 0, errors.Trace(ui.activate(os.Stdout, &sh))
+	},
+}
+
+// reloadFlags contains the flags of command "reload".
+var reloadFlags struct {
+	watch    bool
+	interval time.Duration
+}
+
+var reloadCommand = &command{
+	Command: config.Command{
+		Name: "reload",
+		Desc: "Print the code that reloads all profiles of the current shell from the configuration (e.g., eval \"$(gosh reload)\"), or with -watch, notify when the configuration changes.",
+		Flag: func(fl *flag.FlagSet) {
+			fl.BoolVar(&reloadFlags.watch, "watch", false, "Instead of reloading, print a notice to stderr each time the configuration or a file of the current shell's profiles changes, until the shell exits (e.g., \"gosh reload -watch &\").")
+			fl.DurationVar(&reloadFlags.interval, "interval", 2*time.Second, "Check for changes with -watch every `duration`.")
+		},
+	},
+	Run: func(ui *CLI) (int, error) {
+		sh, ok := ui.Config.Shell[ui.Param.Shell]
+		if !ok {
+			return 0, errors.Errorf("undefined shell: %s", ui.Param.Shell)
+		}
+		if reloadFlags.watch {
+			return 0, errors.Trace(ui.watch(os.Stderr, &sh, reloadFlags.interval))
+		}
+		return 0, errors.Trace(ui.reload(os.Stdout, &sh))
+	},
+}
+
+// sessionParam returns a copy of the user's parameters with the profiles of the
+// current gosh session, followed by the given profiles, selected.
+func (ui *CLI) sessionParam(add ...string) *config.Parameters {
+	p := *ui.Param
+	p.Profiles = append(append(config.ProfileList{}, ui.sessionProfiles()...), add...)
+	return &p
+}
+
+// definedProfiles returns the profiles in the load order of par that are defined
+// in the configuration.
+func (ui *CLI) definedProfiles(par *config.Parameters) []string {
+	var def []string
+	for _, name := range par.ProfileOrder() {
+		if _, ok := ui.Config.Profile[name]; ok {
+			def = append(def, name)
+		}
+	}
+	return def
+}
+
+// exportVars returns a statement exporting each of the given variables
+// (formatted as os.Environ) in the given dialect.
+func exportVars(d shell.Dialect, vars []string) []string {
+	code := make([]string, 0, len(vars))
+	for _, kv := range vars {
+		k, v := environ.Split(kv)
+		code = append(code, d.Export(k, v))
+	}
+	return code
+}
+
+// activate writes the code that loads each selected profile (and the profiles
+// it inherits) not already loaded by the current session: the variables of its
+// env files and secrets, followed by its goshrc content.
+func (ui *CLI) activate(out io.Writer, sh *config.Shell) error {
+	cur := ui.sessionParam()
+	par := ui.sessionParam(ui.Param.Profiles...)
+	loaded := map[string]bool{}
+	for _, name := range ui.definedProfiles(cur) {
+		loaded[name] = true
+	}
+	var add []string
+	for _, name := range ui.definedProfiles(par) {
+		if !loaded[name] {
+			add = append(add, name)
+		}
+	}
+	if len(add) == 0 {
+		ui.Log.Context().
+			WithField("profiles", ui.Param.Profiles).
+			Warn("profiles already loaded")
+	}
+
+	vars, secret, err := ui.readVars(add)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	ui.Log.Redactor().Secret(secret...)
+
+	dialect := shell.DialectOf(sh)
+	code := []string{fmt.Sprintf("# %s activate: %s", ui.Param.App.PackageName, strings.Join(add, ", "))}
+	code = append(code, exportVars(dialect, vars)...)
+	code = append(code, ui.sessionExports(dialect, ui.definedProfiles(par))...)
+	if _, err := fmt.Fprintln(out, strings.Join(code, "\n")); err != nil {
+		return errors.Trace(err)
+	}
+	source := ui.readProfile(sh)
+	for _, name := range add {
+		if err := source(out, name); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// reload writes the code that loads all profiles of the current session again:
+// the variables of their env files and secrets, followed by the content of a
+// goshrc generated from the current configuration.
+func (ui *CLI) reload(out io.Writer, sh *config.Shell) error {
+	par := ui.sessionParam()
+	vars, secret, err := ui.readVars(par.ProfileOrder())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	ui.Log.Redactor().Secret(secret...)
+
+	ss, err := shell.Prepare(par, ui.Log, ui.Config, sh, ui.readProfile(sh), ui.goshrcCache(sh, par), vars)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer ss.Close()
+	rc, err := ioutil.ReadFile(ss.RCFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	dialect := shell.DialectOf(sh)
+	code := []string{fmt.Sprintf("# %s reload: %s", ui.Param.App.PackageName, strings.Join(ss.Profiles, ", "))}
+	code = append(code, exportVars(dialect, vars)...)
+	code = append(code, ui.sessionExports(dialect, ss.Profiles)...)
+	if ss.Cached() {
+		// the goshrc remains for locating errors, same as a new session
+		code = append(code, dialect.Export("GOSH_RCFILE", ss.RCFile))
+	}
+	if _, err := fmt.Fprintln(out, strings.Join(code, "\n")); err != nil {
+		return errors.Trace(err)
+	}
+	_, err = out.Write(rc)
+	return errors.Trace(err)
+}
+
+// watchFiles returns the configuration file and each env and include file of the
+// profiles of the current session.
+func (ui *CLI) watchFiles() []string {
+	par := ui.sessionParam()
+	root := filepath.Dir(ui.Param.ConfigPath)
+	files := []string{ui.Param.ConfigPath}
+	for _, name := range ui.definedProfiles(par) {
+		pro := ui.Config.Profile[name]
+		dir := filepath.Join(root, name)
+		for _, file := range pro.EnvFile {
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(dir, file)
+			}
+			files = append(files, file)
+		}
+		for _, file := range pro.Include {
+			files = append(files, filepath.Join(dir, file))
+		}
+	}
+	return files
+}
+
+// watch polls the files of the current session every interval and writes a
+// notice to out when any of them changes, until the shell that started gosh
+// exits.
+func (ui *CLI) watch(out io.Writer, sh *config.Shell, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.NotValidf("interval %s", interval)
+	}
+	stat := func() map[string]time.Time {
+		mod := map[string]time.Time{}
+		for _, path := range ui.watchFiles() {
+			if info, err := os.Stat(path); err == nil {
+				mod[path] = info.ModTime()
+			} else {
+				mod[path] = time.Time{}
+			}
+		}
+		return mod
+	}
+	// the files of each profile are those configured when the watch began, but
+	// any change to the configuration file itself is reported.
+	parent := os.Getppid()
+	last := stat()
+	ui.Log.Context().
+		WithField("files", len(last)).
+		WithField("interval", interval).
+		Info("watching configuration")
+	for range time.Tick(interval) {
+		if os.Getppid() != parent {
+			return nil // the shell has exited
+		}
+		next := stat()
+		var changed []string
+		for path, mod := range next {
+			if prev, ok := last[path]; !ok || !prev.Equal(mod) {
+				changed = append(changed, path)
+			}
+		}
+		for path := range last {
+			if _, ok := next[path]; !ok {
+				changed = append(changed, path)
+			}
+		}
+		last = next
+		if len(changed) > 0 {
+			sort.Strings(changed)
+			reload := fmt.Sprintf(`eval "$(%s reload)"`, ui.Param.App.PackageName)
+			if shell.DialectOf(sh) == shell.DialectFish {
+				reload = fmt.Sprintf("%s reload | source", ui.Param.App.PackageName)
+			}
+			fmt.Fprintf(out, "\n%s: changed: %s\n%s: run '%s' to reload profiles\n",
+				ui.Param.App.PackageName, strings.Join(changed, ", "),
+				ui.Param.App.PackageName, reload)
+		}
+	}
+	return nil
+}
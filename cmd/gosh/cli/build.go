@@ -0,0 +1,239 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/juju/errors"
+)
+
+// Import paths of the gosh module and the package that implements the gosh
+// command, which is imported by the package generated by command "build".
+const (
+	modulePath = "github.com/ardnew/gosh"
+	appPath    = modulePath + "/cmd/gosh/app"
+)
+
+// embedDir is the directory in the generated package that contains the
+// configuration embedded in the executable.
+const embedDir = "config"
+
+// buildFlags contains the flags of command "build".
+var buildFlags struct {
+	out     string
+	src     string
+	work    bool
+	secrets bool
+}
+
+var buildCommand = &command{
+	Command: config.Command{
+		Name: "build",
+		Desc: "Build a gosh executable with the configuration file and the directory of each of its profiles embedded, which runs without any configuration on the host (requires the Go toolchain).",
+		Flag: func(fl *flag.FlagSet) {
+			fl.StringVar(&buildFlags.out, "out", "", "Write the executable to file `path`.")
+			fl.StringVar(&buildFlags.src, "src", "", "Build with the gosh module source in directory `path` instead of the version of gosh running.")
+			fl.BoolVar(&buildFlags.work, "work", false, "Print the directory of the generated package and do not remove it.")
+			fl.BoolVar(&buildFlags.secrets, "secrets", false, "Also embed the files read by secret providers (secret.file), which are otherwise excluded.")
+		},
+	},
+	Run: func(ui *CLI) (int, error) {
+		if buildFlags.out == "" {
+			return 0, errors.New("no executable path (use -out path)")
+		}
+		return 0, errors.Trace(ui.build(buildFlags.out, buildFlags.src, buildFlags.work, buildFlags.secrets))
+	},
+}
+
+// buildFileKind returns the kind of each file in a profile directory that is
+// referenced by the configuration as an env file or a secret file, keyed by
+// its path (slash-separated) relative to the configuration directory.
+func (ui *CLI) buildFileKind(profiles []string) map[string]string {
+	kind := map[string]string{}
+	for _, name := range profiles {
+		pro := ui.Config.Profile[name]
+		for _, file := range pro.EnvFile {
+			// a file also read by a secret provider remains a secret file
+			if fp := path.Join(name, filepath.ToSlash(file)); !filepath.IsAbs(file) && kind[fp] == "" {
+				kind[fp] = "envfile"
+			}
+		}
+		for _, sec := range pro.Secret {
+			if sec.File != "" && !filepath.IsAbs(sec.File) {
+				kind[path.Join(name, filepath.ToSlash(sec.File))] = "secret"
+			}
+		}
+	}
+	return kind
+}
+
+// buildRequire returns the requirement and replacement (if any) of the gosh
+// module in the go.mod file of the generated package: the module source in
+// directory src, if given, or else the version of gosh running, if built from a
+// released module version.
+func buildRequire(src string) (require, replace string, err error) {
+	if src != "" {
+		abs, err := filepath.Abs(src)
+		if err != nil {
+			return "", "", errors.Trace(err)
+		}
+		if _, err := os.Stat(filepath.Join(abs, "go.mod")); err != nil {
+			return "", "", errors.Annotate(err, "gosh module source")
+		}
+		return modulePath + " v0.0.0", modulePath + " => " + abs, nil
+	}
+	info, ok := debug.ReadBuildInfo()
+	// a version with uncommitted changes (e.g., "v0.5.1-0.20261019-abcdef+dirty")
+	// cannot be downloaded
+	if ok && info.Main.Path == modulePath &&
+		strings.HasPrefix(info.Main.Version, "v") && !strings.HasSuffix(info.Main.Version, "+dirty") {
+		return modulePath + " " + info.Main.Version, "", nil
+	}
+	return "", "", errors.New("gosh module version unknown (use -src with a copy of the gosh source)")
+}
+
+// build generates a Go main package that embeds the configuration file and the
+// directory of each profile, and builds it into executable out with the Go
+// toolchain. The package is generated in a temporary directory, which is
+// removed afterward unless keep is true. The files read by secret providers are
+// not embedded unless secrets is true.
+func (ui *CLI) build(out, src string, keep, secrets bool) (err error) {
+
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		return errors.Annotate(err, "Go toolchain")
+	}
+	require, replace, err := buildRequire(src)
+	if err != nil {
+		return err
+	}
+	if out, err = filepath.Abs(out); err != nil {
+		return errors.Trace(err)
+	}
+
+	work, err := ioutil.TempDir("", ui.Param.App.PackageName+"-build-")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if keep {
+		fmt.Fprintln(os.Stderr, "WORK="+work)
+	} else {
+		defer os.RemoveAll(work)
+	}
+
+	write := func(name string, data []byte, perm os.FileMode) error {
+		fp := filepath.Join(work, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fp), ui.Param.App.PermConfigDir); err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(ioutil.WriteFile(fp, data, perm))
+	}
+
+	// the configuration file is embedded with its default name, so that it is
+	// found when no configuration file is given with flag -f.
+	data, err := ui.readConfigFile(ui.Param.ConfigPath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := write(path.Join(embedDir, ui.Param.App.FileConfigName), data, ui.Param.App.PermConfigFile); err != nil {
+		return err
+	}
+	profiles := make([]string, 0, len(ui.Config.Profile))
+	for name := range ui.Config.Profile {
+		profiles = append(profiles, name)
+	}
+	sort.Strings(profiles)
+	kind := ui.buildFileKind(profiles)
+	files, excluded := 0, 0
+	err = ui.walkProfiles(profiles, func(name string, data []byte, info fs.FileInfo) error {
+		ctx := ui.Log.Context().WithField("file", name)
+		switch kind[name] {
+		case "secret":
+			if !secrets {
+				excluded++
+				ctx.Warn("secret file not embedded (use -secrets to embed it)")
+				return nil
+			}
+			ctx.Warn("embedding secret file")
+		case "envfile":
+			ctx.Info("embedding env file")
+		default:
+			ctx.Debug("embedding file")
+		}
+		files++
+		return write(path.Join(embedDir, name), data, info.Mode().Perm())
+	})
+	if err != nil {
+		return err
+	}
+
+	mod := fmt.Sprintf("module %s-embedded\n\ngo 1.18\n\nrequire %s\n", ui.Param.App.PackageName, require)
+	if replace != "" {
+		mod += fmt.Sprintf("\nreplace %s\n", replace)
+		// start with the checksums of the gosh module's own requirements
+		if sum, err := ioutil.ReadFile(filepath.Join(src, "go.sum")); err == nil {
+			if err := write("go.sum", sum, 0o644); err != nil {
+				return err
+			}
+		}
+	}
+	if err := write("go.mod", []byte(mod), 0o644); err != nil {
+		return err
+	}
+	main := fmt.Sprintf(buildMain, ui.Param.App.PackageName, appPath, embedDir, embedDir)
+	if err := write("main.go", []byte(main), 0o644); err != nil {
+		return err
+	}
+
+	ui.Log.Context().
+		WithField("profiles", len(profiles)).
+		WithField("files", files).
+		WithField("excluded", excluded).
+		WithField("require", require).
+		WithField("work", work).
+		Info("building executable")
+
+	cmd := exec.Command(gobin, "build", "-mod=mod", "-trimpath", "-o", out, ".")
+	cmd.Dir = work
+	cmd.Env = append(os.Environ(), "GOWORK=off")
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Annotate(err, "go build")
+	}
+	ui.Log.Context().WithField("path", out).Info("built executable")
+	return nil
+}
+
+// buildMain is the template of the main package generated by command "build".
+const buildMain = `// Code generated by %s build; DO NOT EDIT.
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+
+	%q
+)
+
+//go:embed all:%s
+var embedded embed.FS
+
+func main() {
+	config, err := fs.Sub(embedded, %q)
+	if err != nil {
+		panic(err)
+	}
+	app.Main(config)
+}
+`
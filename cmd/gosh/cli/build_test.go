@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+)
+
+func TestBuildFileKind(t *testing.T) {
+	ui := &CLI{Config: &config.Config{Profile: config.Profiles{
+		"a": {
+			EnvFile: []string{"a.env", "/etc/host.env"},
+			Secret: config.Secrets{
+				"TOKEN":  {File: "./keys/token"},
+				"SHARED": {File: "../b/shared"},
+				"HOST":   {File: "/etc/token"},
+				"CMD":    {Command: []string{"pass", "token"}},
+			},
+		},
+		"b": {EnvFile: []string{"sub/b.env", "shared"}},
+	}}}
+	want := map[string]string{
+		"a/a.env":      "envfile",
+		"a/keys/token": "secret",
+		"b/shared":     "secret",
+		"b/sub/b.env":  "envfile",
+	}
+	if got := ui.buildFileKind([]string{"a", "b"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildFileKind() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,426 @@
+package cli
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/shell"
+	"github.com/ardnew/version"
+	"github.com/juju/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// bundleFlags contains the flags of command "bundle".
+var bundleFlags struct {
+	out string
+	tar bool
+}
+
+var bundleCommand = &command{
+	Command: config.Command{
+		Name: "bundle",
+		Desc: "Print a standalone POSIX shell script that starts the shell with the goshrc of the profiles selected with -p (along with \"auto\") embedded, for hosts without gosh installed, or with -tar, an archive of their configuration.",
+		Flag: func(fl *flag.FlagSet) {
+			fl.StringVar(&bundleFlags.out, "out", "", "Write the bundle to file `path` instead of stdout.")
+			fl.BoolVar(&bundleFlags.tar, "tar", false, "Print a tar archive containing the directory of each bundled profile and a configuration file defining only the bundled shell and profiles, instead of a script.")
+		},
+	},
+	Run: func(ui *CLI) (int, error) {
+		sh, ok := ui.Config.Shell[ui.Param.Shell]
+		if !ok {
+			return 0, errors.Errorf("undefined shell: %s", ui.Param.Shell)
+		}
+		var buf bytes.Buffer
+		var err error
+		mode := os.FileMode(0o755)
+		if bundleFlags.tar {
+			err = ui.bundleTar(&buf, &sh)
+			mode = ui.Param.App.PermConfigFile
+		} else {
+			err = ui.bundleScript(&buf, &sh)
+		}
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		if bundleFlags.out == "" {
+			_, err = buf.WriteTo(os.Stdout)
+			return 0, errors.Trace(err)
+		}
+		if err := ioutil.WriteFile(bundleFlags.out, buf.Bytes(), mode); err != nil {
+			return 0, errors.Trace(err)
+		}
+		ui.Log.Context().
+			WithField("path", bundleFlags.out).
+			WithField("size", fmt.Sprintf("%dB", buf.Len())).
+			Info("wrote bundle")
+		return 0, nil
+	},
+}
+
+// bundleManifest describes the content of a bundle.
+type bundleManifest struct {
+	Version  string         `yaml:"version"`
+	Shell    string         `yaml:"shell"`
+	Profiles []string       `yaml:"profiles,flow"`
+	Source   []bundleSource `yaml:"source"`
+}
+
+// bundleSource identifies a file from which a bundle was made, relative to the
+// configuration directory.
+type bundleSource struct {
+	Path   string `yaml:"path"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// newBundleManifest returns the manifest of a bundle of the given shell and
+// profiles.
+func (ui *CLI) newBundleManifest(profiles []string) *bundleManifest {
+	return &bundleManifest{
+		Version:  version.String(),
+		Shell:    ui.Param.Shell,
+		Profiles: profiles,
+		Source:   []bundleSource{},
+	}
+}
+
+// add adds the file with the given content to the manifest.
+func (man *bundleManifest) add(name string, data []byte) {
+	sum := sha256.Sum256(data)
+	man.Source = append(man.Source, bundleSource{Path: name, SHA256: hex.EncodeToString(sum[:])})
+}
+
+// bundleFiles returns the path of each file read from the directory of each of
+// the given profiles to generate their goshrc, relative to the configuration
+// directory.
+func (ui *CLI) bundleFiles(profiles []string) []string {
+	var files []string
+	for _, name := range profiles {
+		pro := ui.Config.Profile[name]
+		for _, file := range pro.EnvFile {
+			if filepath.IsAbs(file) {
+				ui.Log.Context().
+					WithField("profile", name).
+					WithField("envfile", file).
+					Warn("absolute path not bundled")
+				continue
+			}
+			files = append(files, filepath.Join(name, file))
+		}
+		for _, file := range pro.Include {
+			files = append(files, filepath.Join(name, file))
+		}
+	}
+	return files
+}
+
+// bundleParam returns a copy of the user's parameters used to generate the
+// goshrc of a bundle, which must not depend on any file or variable of the
+// current host.
+func (ui *CLI) bundleParam() *config.Parameters {
+	par := *ui.Param
+	par.GenerateGoshrc = true
+	par.OrphanEnviron = true
+	return &par
+}
+
+// bundleScript writes a POSIX shell script that writes the goshrc of the
+// selected profiles to a temporary file, and starts the given shell with it.
+// The goshrc contains the variables of each profile's env files, but not its
+// secrets, which are never written to a goshrc.
+func (ui *CLI) bundleScript(out io.Writer, sh *config.Shell) error {
+
+	// the goshrc is generated the same as with flag -d, so that each include
+	// file is copied into it.
+	user, conf := ui.Param, ui.Config
+	ui.Param = ui.bundleParam()
+	defer func() { ui.Param, ui.Config = user, conf }()
+
+	profiles := ui.definedProfiles(ui.Param)
+
+	// the isolated history, process attributes, sandbox, and hooks of a profile
+	// are applied by gosh itself, which is not run by the script.
+	cfg := *conf
+	cfg.Profile = config.Profiles{}
+	for name, pro := range conf.Profile {
+		cfg.Profile[name] = pro
+	}
+	for _, name := range profiles {
+		pro := cfg.Profile[name]
+		var skip []string
+		if pro.History != nil {
+			skip = append(skip, "history")
+		}
+		if pro.Process != nil {
+			skip = append(skip, "process")
+		}
+		if pro.Sandbox != nil {
+			skip = append(skip, "sandbox")
+		}
+		if len(pro.Hooks.Pre) > 0 || len(pro.Hooks.Post) > 0 {
+			skip = append(skip, "hooks")
+		}
+		if len(skip) > 0 {
+			ui.Log.Context().
+				WithField("profile", name).
+				WithField("keys", skip).
+				Warn("profile keys not bundled")
+		}
+		pro.History = nil
+		cfg.Profile[name] = pro
+	}
+	ui.Config = &cfg
+	vars, secret, err := ui.readUnresolvedVars(profiles)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	ui.Log.Redactor().Secret(secret...)
+	if len(secret) > 0 {
+		ui.Log.Context().
+			WithField("secret", secret).
+			Warn("secrets not bundled")
+	}
+
+	ss, err := shell.Prepare(ui.Param, ui.Log, ui.Config, sh, ui.readProfile(sh), nil, vars)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer ss.Close()
+	var rc bytes.Buffer
+	if err := ss.Generate(&rc); err != nil {
+		return errors.Trace(err)
+	}
+
+	man := ui.newBundleManifest(profiles)
+	root := filepath.Dir(ui.Param.ConfigPath)
+	for _, file := range append([]string{filepath.Base(ui.Param.ConfigPath)}, ui.bundleFiles(profiles)...) {
+		data, err := ui.readConfigFile(filepath.Join(root, file))
+		if err != nil {
+			continue // already reported when generating the goshrc
+		}
+		man.add(filepath.ToSlash(file), data)
+	}
+	head, err := yaml.Marshal(man)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// the goshrc is embedded in a here-document, whose delimiter must not appear
+	// on a line of its own in the goshrc.
+	sum := sha256.Sum256(rc.Bytes())
+	eof := fmt.Sprintf("GOSH_BUNDLE_%X", sum[:4])
+	for _, line := range strings.Split(rc.String(), "\n") {
+		if line == eof {
+			return errors.Errorf("goshrc contains here-document delimiter %q", eof)
+		}
+	}
+
+	// the shell's arguments are expanded the same as by gosh, but the goshrc,
+	// working directory, and arguments are only known when the script is run.
+	const (
+		argExec   = "\x00exec"
+		argRCFile = "\x00rcfile"
+		argPwd    = "\x00pwd"
+		argArgs   = "\x00args"
+	)
+	exp := config.NewArgExpansion(ui.Param.App.PackageName, argExec, argRCFile, argPwd, ui.Param.ShellCommand, argArgs)
+	tmpl := []string{argExec}
+	if ui.Param.ShellCommand != "" {
+		tmpl = append(tmpl, sh.Flag.CommandLine...)
+	} else if ui.Param.LoginShell {
+		tmpl = append(tmpl, sh.Flag.LoginShell...)
+	} else if ui.Param.Interactive {
+		tmpl = append(tmpl, sh.Flag.Interactive...)
+	}
+	var args []string
+	for _, arg := range exp.ExpandArgs(tmpl...) {
+		switch arg {
+		case argExec:
+			args = append(args, `"$exe"`)
+		case argRCFile:
+			args = append(args, `"$rc"`)
+		case argPwd:
+			args = append(args, `"$PWD"`)
+		case argArgs:
+			args = append(args, `"$@"`)
+		case "":
+		default:
+			args = append(args, shell.DialectPOSIX.Quote(arg))
+		}
+	}
+
+	pkg := ui.Param.App.PackageName
+	q := shell.DialectPOSIX.Quote
+	var b strings.Builder
+	fmt.Fprintf(&b, "#!/bin/sh\n# %s bundle\n#\n", pkg)
+	for _, line := range strings.Split(strings.TrimSpace(string(head)), "\n") {
+		fmt.Fprintf(&b, "#   %s\n", line)
+	}
+	fmt.Fprintf(&b, "#\n# Starts %s with the goshrc below, which is removed when the shell exits.\n\n", sh.Exec)
+	fmt.Fprintf(&b, "exe=%s\n", q(sh.Exec))
+	fmt.Fprintf(&b, "if [ ! -x \"$exe\" ]; then\n")
+	fmt.Fprintf(&b, "\texe=$(command -v %s) || { echo %s >&2; exit 127; }\n",
+		q(filepath.Base(sh.Exec)), q(fmt.Sprintf("%s bundle: %s: not found", pkg, filepath.Base(sh.Exec))))
+	fmt.Fprintf(&b, "fi\n")
+	fmt.Fprintf(&b, "rc=$(mktemp \"${TMPDIR:-/tmp}/%src-XXXXXX\") || exit 1\n", pkg)
+	fmt.Fprintf(&b, "trap 'rm -f \"$rc\"' EXIT\n")
+	fmt.Fprintf(&b, "trap 'exit 129' HUP\n")
+	fmt.Fprintf(&b, "trap 'exit 143' TERM\n")
+	fmt.Fprintf(&b, "cat >\"$rc\" <<'%s'\n", eof)
+	if _, err := io.WriteString(out, b.String()); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := out.Write(rc.Bytes()); err != nil {
+		return errors.Trace(err)
+	}
+	b.Reset()
+	if rc.Len() > 0 && !bytes.HasSuffix(rc.Bytes(), []byte("\n")) {
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "%s\n", eof)
+	fmt.Fprintf(&b, "export GOSH_RCFILE=\"$rc\"\n")
+	fmt.Fprintf(&b, "export GOSH_PROFILE=%s\n", q(strings.Join(profiles, ",")))
+	fmt.Fprintf(&b, "%s\n", strings.Join(args, " "))
+	_, err = io.WriteString(out, b.String())
+	return errors.Trace(err)
+}
+
+// bundleTar writes a tar archive containing a configuration file that defines
+// only the given shell and the selected profiles (and the profiles they
+// inherit), along with each file in the directory of each profile, and a
+// manifest. The shell is renamed to the default shell, and all paths in the
+// archive are relative to a directory named for the application, so that the
+// archive can be extracted directly into the user's configuration directory.
+func (ui *CLI) bundleTar(out io.Writer, sh *config.Shell) error {
+
+	profiles := ui.definedProfiles(ui.Param)
+	man := ui.newBundleManifest(profiles)
+	man.Shell = ui.Param.App.ReqShellName
+
+	cfg := *ui.Config
+	cfg.Shell = config.Shells{ui.Param.App.ReqShellName: *sh}
+	cfg.Profile = config.Profiles{}
+	for _, name := range profiles {
+		cfg.Profile[name] = ui.Config.Profile[name]
+	}
+	data, err := cfg.Marshal()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	pkg := ui.Param.App.PackageName
+	now := time.Now()
+	tw := tar.NewWriter(out)
+	dirs := map[string]bool{}
+	addDir := func(dir string) error {
+		for _, d := range []string{path.Dir(dir), dir} {
+			if d == "." || dirs[d] {
+				continue
+			}
+			dirs[d] = true
+			if err := tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     d + "/",
+				Mode:     int64(ui.Param.App.PermConfigDir),
+				ModTime:  now,
+			}); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	}
+	addFile := func(name string, data []byte, mode os.FileMode, mod time.Time) error {
+		name = path.Join(pkg, name)
+		if err := addDir(path.Dir(name)); err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     int64(mode.Perm()),
+			Size:     int64(len(data)),
+			ModTime:  mod,
+		}); err != nil {
+			return errors.Trace(err)
+		}
+		_, err := tw.Write(data)
+		return errors.Trace(err)
+	}
+
+	cfgName := ui.Param.App.FileConfigName
+	if err := addFile(cfgName, data, ui.Param.App.PermConfigFile, now); err != nil {
+		return err
+	}
+	man.add(cfgName, data)
+
+	err = ui.walkProfiles(profiles, func(name string, data []byte, info fs.FileInfo) error {
+		man.add(name, data)
+		return addFile(name, data, info.Mode(), info.ModTime())
+	})
+	if err != nil {
+		return err
+	}
+	root := filepath.Dir(ui.Param.ConfigPath)
+	for _, file := range ui.bundleFiles(profiles) {
+		if _, err := ui.statConfigFile(filepath.Join(root, file)); err != nil {
+			ui.Log.Context().WithError(errors.Trace(err)).Warn("file not bundled")
+		}
+	}
+
+	head, err := yaml.Marshal(man)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := addFile("BUNDLE", head, ui.Param.App.PermConfigFile, now); err != nil {
+		return err
+	}
+	return errors.Trace(tw.Close())
+}
+
+// walkProfiles calls fn with the name (relative to the configuration directory),
+// content, and file info of each regular file in the directory of each of the
+// given profiles, including the files linked to by any symbolic links.
+func (ui *CLI) walkProfiles(profiles []string, fn func(name string, data []byte, info fs.FileInfo) error) error {
+	fsys := ui.Param.FS()
+	for _, name := range profiles {
+		if !fs.ValidPath(name) {
+			continue
+		}
+		if _, err := fs.Stat(fsys, name); os.IsNotExist(err) {
+			continue
+		}
+		err := fs.WalkDir(fsys, name, func(fp string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			info, err := fs.Stat(fsys, fp)
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			data, err := fs.ReadFile(fsys, fp)
+			if err != nil {
+				return err
+			}
+			return fn(fp, data, info)
+		})
+		if err != nil {
+			return errors.Annotatef(err, "profile %q", name)
+		}
+	}
+	return nil
+}
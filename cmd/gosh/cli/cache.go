@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/shell"
+	"github.com/ardnew/version"
+	"github.com/juju/errors"
+)
+
+// goshrcCache returns the cache from which the goshrc of the given shell and
+// parameters is reused, or nil if goshrc files are not cached.
+func (ui *CLI) goshrcCache(sh *config.Shell, par *config.Parameters) *shell.RCCache {
+	if ui.Config.Cache.Disable {
+		return nil
+	}
+	maxAge := shell.DefaultRCCacheMaxAge
+	if ui.Config.Cache.MaxAge != "" {
+		age, err := time.ParseDuration(ui.Config.Cache.MaxAge)
+		if err != nil {
+			ui.Log.Context().
+				WithField("maxage", ui.Config.Cache.MaxAge).
+				WithError(errors.Trace(err)).
+				Warn("invalid goshrc cache age (using default)")
+		} else {
+			maxAge = age
+		}
+	}
+	key, err := ui.goshrcKey(sh, par)
+	if err != nil {
+		// regenerate the goshrc, reporting any unreadable files as usual
+		ui.Log.Context().WithError(err).Debug("goshrc not cached")
+		return nil
+	}
+	return &shell.RCCache{
+		Dir:     filepath.Join(ui.Param.App.CacheDir(), "goshrc"),
+		Key:     key,
+		MaxAge:  maxAge,
+		PermDir: ui.Param.App.PermConfigDir,
+	}
+}
+
+// goshrcKey returns a digest of everything that determines the content of the
+// goshrc generated from the loaded profiles: the gosh version, the content of
+// the configuration file, and the env, assembly method, and include files of
+// each profile. Include files are identified by path, size, and modification
+// time, so that they do not need to be read to know the goshrc is unchanged,
+// unless embedded in the executable (which have no modification time).
+func (ui *CLI) goshrcKey(sh *config.Shell, par *config.Parameters) ([]byte, error) {
+	cfg, err := filepath.Abs(par.ConfigPath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	data, err := ui.readConfigFile(par.ConfigPath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00", version.String(), cfg, len(data))
+	h.Write(data)
+	fmt.Fprintf(h, "\x00%s\x00%t\x00", sh.Assemble, par.GenerateGoshrc)
+
+	root := filepath.Dir(par.ConfigPath)
+	for _, name := range par.ProfileOrder() {
+		pro, ok := ui.Config.Profile[name]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00%q\x00", name, pro.Assemble, strings.Join(pro.Env, "\n"))
+		for _, file := range pro.Include {
+			path := filepath.Join(root, name, file)
+			info, err := ui.statConfigFile(path)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			fmt.Fprintf(h, "%s\x00%d\x00%d\x00", path, info.Size(), info.ModTime().UnixNano())
+			if info.ModTime().IsZero() {
+				// files embedded in the executable have no modification time
+				data, err := ui.readConfigFile(path)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				h.Write(data)
+			}
+		}
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/juju/errors"
+)
+
+// checkFlags contains the flags of command "check".
+var checkFlags struct {
+	scripts bool
+}
+
+var checkCommand = &command{
+	Command: config.Command{
+		Name: "check",
+		Desc: "Verify that all include and env files of each profile (or only those selected with -p) can be read, and exit with non-zero status otherwise.",
+		Flag: func(fl *flag.FlagSet) {
+			fl.BoolVar(&checkFlags.scripts, "scripts", false, "Also check the syntax of each include file with the shell's parser (e.g., \"bash -n\").")
+		},
+	},
+	Run: func(ui *CLI) (int, error) {
+		sh, ok := ui.Config.Shell[ui.Param.Shell]
+		if !ok {
+			return 0, errors.Errorf("undefined shell: %s", ui.Param.Shell)
+		}
+		res := ui.check(&sh, checkFlags.scripts)
+		failed := 0
+		for _, r := range res {
+			if r.err == nil {
+				fmt.Printf("ok    %s\n", r.name)
+				continue
+			}
+			failed++
+			fmt.Printf("FAIL  %s\n", r.name)
+			for _, line := range strings.Split(strings.TrimRight(r.err.Error(), "\n"), "\n") {
+				fmt.Printf("      %s\n", line)
+			}
+		}
+		fmt.Printf("checked %d files, %d failed\n", len(res), failed)
+		if failed > 0 {
+			return 1, nil
+		}
+		return 0, nil
+	},
+}
+
+// checkResult is the result of checking a single file of a profile.
+type checkResult struct {
+	name string // profile/file
+	path string
+	kind string // "include" or "envfile"
+	err  error
+}
+
+// checkProfiles returns the names of the profiles to check: those selected with
+// -p (along with the auto profile), or else every profile in the configuration.
+func (ui *CLI) checkProfiles() []string {
+	if len(ui.Param.Profiles) > 0 {
+		return ui.Param.ProfileOrder()
+	}
+	names := make([]string, 0, len(ui.Config.Profile))
+	for name := range ui.Config.Profile {
+		if name != ui.Param.App.ReqProfileName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if _, ok := ui.Config.Profile[ui.Param.App.ReqProfileName]; ok {
+		names = append([]string{ui.Param.App.ReqProfileName}, names...)
+	}
+	return names
+}
+
+// check verifies each include and env file of the profiles to check can be read
+// (or parsed, for env files), and, if scripts is true, that each include file
+// has valid syntax according to the given shell's no-exec parser ("-n").
+func (ui *CLI) check(sh *config.Shell, scripts bool) []checkResult {
+	root := filepath.Dir(ui.Param.ConfigPath)
+	var res []checkResult
+	for _, name := range ui.checkProfiles() {
+		pro, ok := ui.Config.Profile[name]
+		if !ok {
+			res = append(res, checkResult{name: name, err: errors.NotFoundf("profile %q", name)})
+			continue
+		}
+		dir := filepath.Join(root, name)
+		for _, file := range pro.EnvFile {
+			path := file
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, file)
+			}
+			_, err := ui.parseEnvFile(path, nil)
+			res = append(res, checkResult{name: filepath.Join(name, file), path: path, kind: "envfile", err: err})
+		}
+		for _, file := range pro.Include {
+			path := filepath.Join(dir, file)
+			_, err := ui.readConfigFile(path)
+			res = append(res, checkResult{name: filepath.Join(name, file), path: path, kind: "include", err: err})
+		}
+	}
+	if !scripts {
+		return res
+	}
+
+	// check the syntax of all readable includes concurrently
+	work := sync.WaitGroup{}
+	limit := make(chan struct{}, runtime.NumCPU())
+	for i := range res {
+		if res[i].kind != "include" || res[i].err != nil {
+			continue
+		}
+		work.Add(1)
+		limit <- struct{}{}
+		go func(r *checkResult) {
+			defer func() { <-limit; work.Done() }()
+			var out bytes.Buffer
+			cmd := exec.Command(sh.Exec, "-n", r.path)
+			cmd.Stdout, cmd.Stderr = &out, &out
+			if err := cmd.Run(); err != nil {
+				if out.Len() == 0 {
+					r.err = err
+				} else {
+					r.err = errors.New(out.String())
+				}
+			}
+		}(&res[i])
+	}
+	work.Wait()
+	return res
+}
@@ -1,14 +1,18 @@
 package cli
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/environ"
 	"github.com/ardnew/gosh/cmd/gosh/log"
 	"github.com/ardnew/gosh/cmd/gosh/shell"
 	"github.com/juju/errors"
@@ -16,9 +20,10 @@ import (
 
 // CLI represents the properties of an active command-line session.
 type CLI struct {
-	Param  *config.Parameters
-	Log    *log.Handler
-	Config *config.Config
+	Param   *config.Parameters
+	Log     *log.Handler
+	Config  *config.Config
+	startup *shell.Startup // non-nil only when profiling shell startup
 }
 
 // Start creates a new command-line session with given parameters.
@@ -36,18 +41,36 @@ func Start(param *config.Parameters) (ui *CLI, err error) {
 		Stop(&err)
 
 	// assert the configuration file path's existance
-	err = os.MkdirAll(filepath.Dir(param.ConfigPath), param.App.PermConfigDir)
+	if param.ConfigFS == nil {
+		err = os.MkdirAll(filepath.Dir(param.ConfigPath), param.App.PermConfigDir)
+		if err != nil {
+			err = errors.Trace(err)
+			return
+		}
+	}
+
+	// parse the configuration file
+	ui.Config, err = config.ParseFile(param.FS(), filepath.Base(param.ConfigPath))
 	if err != nil {
 		err = errors.Trace(err)
 		return
 	}
 
-	// parse the configuration file
-	ui.Config, err = config.ParseFile(param.ConfigPath)
+	// resolve the inherited profiles of each profile, loaded before it
+	param.Inherit = map[string][]string{}
+	for name, pro := range ui.Config.Profile {
+		if len(pro.Inherit) > 0 {
+			param.Inherit[name] = pro.Inherit
+		}
+	}
+
+	// apply the user's redaction policy to all subsequent log messages
+	red, err := newRedactor(ui.Config.Redact)
 	if err != nil {
 		err = errors.Trace(err)
 		return
 	}
+	ui.Log.SetRedactor(red)
 
 	ui.Log.Context().
 		WithField("config", ui.Config.String()).
@@ -57,12 +80,23 @@ func Start(param *config.Parameters) (ui *CLI, err error) {
 }
 
 // CreateShell opens and attaches to a new shell as defined in the user's
-// configuration file.
-func (ui *CLI) CreateShell() (err error) {
+// configuration file, and returns the shell's exit status once it exits.
+//
+// A non-nil error is only returned if the shell could not be started; a shell
+// that exits with non-zero status is not an error.
+func (ui *CLI) CreateShell() (status int, err error) {
 
 	sh, ok := ui.Config.Shell[ui.Param.Shell]
 	if !ok {
-		err = errors.Errorf("undefined shell: %s", ui.Param.Shell)
+		return 0, errors.Errorf("undefined shell: %s", ui.Param.Shell)
+	}
+
+	if ui.Param.ProfileStartup != "" {
+		return ui.profileStartup(&sh)
+	}
+
+	if ui.Param.Explain != "" {
+		return ui.explain(&sh)
 	}
 
 	ctx := ui.Log.Context().WithField("exec", sh.Exec)
@@ -73,65 +107,357 @@ func (ui *CLI) CreateShell() (err error) {
 		ctx.Info("running command")
 	}
 
-	err, _ = shell.Run(ui.Param, ui.Log, ui.Config, &sh, ui.readProfile())
-	return
+	// refuse to start a shell nested too deeply, which is most likely a shell
+	// launched by gosh running gosh again from its profiles
+	if !ui.Param.GenerateGoshrc {
+		if err := ui.checkDepth(); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+
+	vars, secret, err := ui.readProfileVars()
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	ui.Log.Redactor().Secret(secret...)
+
+	ss, err := shell.Prepare(ui.Param, ui.Log, ui.Config, &sh, ui.readProfile(&sh), ui.goshrcCache(&sh, ui.Param), vars)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer ss.Close()
+
+	if ui.Param.GenerateGoshrc {
+		return 0, errors.Trace(ss.Generate(os.Stdout))
+	}
+
+	pre, post := ui.hooks(&sh)
+	rec := ui.newSession(&sh, ss)
+	// post-exit hooks cannot run if the shell replaces our process
+	ss.Wait = len(post) > 0
+
+	if err = ui.runHooks(hookPre, pre, ss.Env, ss.Dir); err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	start := time.Now()
+	if rec != nil && ss.Launch() == shell.LaunchExec {
+		// the shell replaces our process, so only its start can be logged
+		rec.Start = start
+		ui.logSession(rec)
+		rec = nil
+	}
+	runErr := ss.Run()
+	status, exited := shell.ExitStatus(runErr)
+	if !exited {
+		return 0, errors.Trace(runErr)
+	}
+	ctx.WithField("status", status).Debug("shell exited")
+
+	if rec != nil {
+		end := time.Now()
+		rec.Start, rec.End, rec.Status = start, &end, &status
+		ui.logSession(rec)
+	}
+
+	ui.runHooks(hookPost, post, environ.Merge(ss.Env,
+		fmt.Sprintf("GOSH_EXIT_STATUS=%d", status),
+		fmt.Sprintf("GOSH_DURATION_MS=%d", time.Since(start).Milliseconds()),
+	), ss.Dir)
+
+	return status, nil
+}
+
+// newRedactor constructs the redaction policy defined by the user's
+// configuration, including the default patterns unless disabled.
+func newRedactor(pol config.Redact) (*environ.Redactor, error) {
+	name, value := pol.Name, pol.Value
+	if !pol.NoDefault {
+		name = append(append([]string{}, environ.DefaultRedactName...), name...)
+		value = append(append([]string{}, environ.DefaultRedactValue...), value...)
+	}
+	return environ.NewRedactor(name, value)
 }
 
-func (ui *CLI) readProfile() *shell.ProfileEnv {
+// readProfileVars parses the dotenv files and resolves the secrets of each
+// selected profile in profile load order, returning the merged variables
+// formatted as os.Environ along with the names of all secret variables.
+// Variables defined later override those of the same name defined earlier, and
+// each dotenv file may reference (via variable expansion) any variable defined
+// before it.
+func (ui *CLI) readProfileVars() (vars []string, secret []string, err error) {
+	return ui.readVars(ui.Param.ProfileOrder())
+}
+
+// readVars is the same as readProfileVars, but for the given profiles instead
+// of those selected by the user.
+func (ui *CLI) readVars(profiles []string) (vars []string, secret []string, err error) {
+	return ui.parseVars(profiles, true)
+}
+
+// readUnresolvedVars is the same as readVars, but without running any secret
+// provider: each secret variable is defined with value environ.Redacted.
+func (ui *CLI) readUnresolvedVars(profiles []string) (vars []string, secret []string, err error) {
+	return ui.parseVars(profiles, false)
+}
+
+func (ui *CLI) parseVars(profiles []string, resolve bool) (vars []string, secret []string, err error) {
 	root := filepath.Dir(ui.Param.ConfigPath)
-	source := shell.ProfileEnv{}
-	for name, pro := range ui.Config.Profile {
-		if _, seen := source[name]; seen {
+	seed := ui.Param.Environ()
+	vars, secret = []string{}, []string{}
+	for _, name := range profiles {
+		pro, ok := ui.Config.Profile[name]
+		if !ok {
+			continue
+		}
+		dir := filepath.Join(root, name)
+		for _, file := range pro.EnvFile {
+			path := file
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, file)
+			}
+			def, err := ui.parseEnvFile(path, environ.Merge(seed, vars...))
+			if err != nil {
+				if err := ui.skipFile(name, err); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			vars = environ.Merge(vars, def...)
 			ui.Log.Context().
 				WithField("profile", name).
-				WithField("reject", "duplicate").
-				Warn("skipping profile")
-		} else {
-			// Insert the profile-specific env before sourcing any of its includes
-			for _, e := range pro.Env {
-				source[name] = append(source[name], e...)
+				WithField("envfile", path).
+				WithField("vars", len(def)).
+				Debug("loaded envfile")
+		}
+		key := make([]string, 0, len(pro.Secret))
+		for k := range pro.Secret {
+			key = append(key, k)
+		}
+		sort.Strings(key)
+		for _, k := range key {
+			if !resolve {
+				vars = environ.Merge(vars, fmt.Sprintf("%s=%s", k, environ.Redacted))
+				secret = append(secret, k)
+				continue
+			}
+			sec := ui.newSecret(name, dir, k, pro.Secret[k])
+			val, cached, err := sec.Resolve()
+			if err != nil {
+				ui.Log.Context().WithError(errors.Trace(err)).Warn("skipping secret")
+				continue
 			}
-			dir := filepath.Join(root, name)
-			source[name] = append(source[name], ui.readProfileMod(dir, pro.Include...)...)
+			vars = environ.Merge(vars, fmt.Sprintf("%s=%s", k, val))
+			secret = append(secret, k)
 			ui.Log.Context().
 				WithField("profile", name).
-				WithField("env", fmt.Sprintf("[ %s ]", strings.Join(pro.Env, ", "))).
-				WithField("size", fmt.Sprintf("%dB", len(source[name]))).
-				WithField("path", dir).
-				Debug("loaded profile")
+				WithField("secret", k).
+				WithField("cached", cached).
+				Debug("resolved secret")
 		}
 	}
+	return vars, secret, nil
+}
 
-	return &source
+// newSecret constructs the provider of secret variable key defined in profile
+// name, whose configuration directory is dir.
+func (ui *CLI) newSecret(name, dir, key string, def config.Secret) *environ.Secret {
+	sec := environ.Secret{
+		Key:      key,
+		Command:  def.Command,
+		File:     def.File,
+		PermFile: ui.Param.App.PermConfigFile,
+		PermDir:  ui.Param.App.PermConfigDir,
+		ReadFile: ui.readConfigFile,
+	}
+	if sec.File != "" && !filepath.IsAbs(sec.File) {
+		sec.File = filepath.Join(dir, sec.File)
+	}
+	if def.TTL != "" {
+		ttl, err := time.ParseDuration(def.TTL)
+		if err != nil {
+			ui.Log.Context().
+				WithField("secret", key).
+				WithError(errors.Trace(err)).
+				Warn("not caching secret")
+		} else {
+			// key the cache on everything that determines the secret's value, so
+			// that changing its provider invalidates any previously cached value.
+			sum := sha256.Sum256([]byte(strings.Join(append([]string{
+				name, key, sec.File}, sec.Command...), "\x00")))
+			sec.Cache = filepath.Join(ui.Param.App.CacheDir(), "secret", hex.EncodeToString(sum[:]))
+			sec.TTL = ttl
+		}
+	}
+	return &sec
 }
 
-func (ui *CLI) readProfileMod(path string, mod ...string) []byte {
+// readProfile returns the source of each profile's goshrc content, which is
+// only read from the profile's files when written by the shell package.
+func (ui *CLI) readProfile(sh *config.Shell) shell.ProfileSource {
+	root := filepath.Dir(ui.Param.ConfigPath)
+	return func(w io.Writer, name string) error {
+		pro := ui.Config.Profile[name]
+		// Insert the profile-specific env before sourcing any of its includes
+		if len(pro.Env) > 0 {
+			if ui.startup != nil {
+				if _, err := w.Write(ui.startup.Marker(name, shell.SourceEnv)); err != nil {
+					return errors.Trace(err)
+				}
+			}
+			var env []byte
+			for _, e := range pro.Env {
+				env = append(append(env, e...), '\n')
+			}
+			if _, err := w.Write(shell.SourceBlock(name, shell.SourceEnv, env)); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		dir := filepath.Join(root, name)
+		var err error
+		if ui.assemble(sh, &pro) == config.AssembleSource {
+			err = ui.sourceProfileMod(w, sh, name, dir, pro.Include...)
+		} else {
+			err = ui.readProfileMod(w, name, dir, pro.Include...)
+		}
+		if err != nil {
+			return err
+		}
+		if ui.startup != nil {
+			if _, err := w.Write(ui.startup.Marker(name, "")); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		ui.Log.Context().
+			WithField("profile", name).
+			WithField("env", pro.Env).
+			WithField("path", dir).
+			Debug("loaded profile")
+		return nil
+	}
+}
 
-	type buf []byte
+// assemble returns the method used to add the include files of the given
+// profile to the goshrc.
+func (ui *CLI) assemble(sh *config.Shell, pro *config.Profile) string {
+	if ui.Param.GenerateGoshrc || ui.Param.ConfigFS != nil {
+		// the printed goshrc must not depend on any other file, and an embedded
+		// configuration has no files on the host to source.
+		return config.AssembleConcat
+	}
+	mode := sh.Assemble
+	if pro.Assemble != "" {
+		mode = pro.Assemble
+	}
+	switch mode {
+	case "", config.AssembleConcat:
+		return config.AssembleConcat
+	case config.AssembleSource:
+		return config.AssembleSource
+	}
+	ui.Log.Context().
+		WithField("assemble", mode).
+		Warn("invalid assemble method (using \"" + config.AssembleConcat + "\")")
+	return config.AssembleConcat
+}
+
+// sourceProfileMod writes a statement for each of the given include files that
+// sources the file by its absolute path, instead of copying its content.
+func (ui *CLI) sourceProfileMod(w io.Writer, sh *config.Shell, name, path string, mod ...string) error {
+	dialect := shell.DialectOf(sh)
+	for _, file := range mod {
+		fp, err := filepath.Abs(filepath.Join(path, file))
+		if err == nil {
+			_, err = os.Stat(fp)
+		}
+		if err != nil {
+			if err := ui.skipFile(name, err); err != nil {
+				return err
+			}
+			continue
+		}
+		var env []byte
+		if ui.startup != nil {
+			env = append(env, ui.startup.Marker(name, file)...)
+		}
+		env = append(env, dialect.Source(fp)+"\n"...)
+		if _, err := w.Write(env); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// loadAhead is the maximum number of include files read concurrently ahead of
+// the file being written to the goshrc.
+const loadAhead = 8
+
+// readProfileMod writes the content of each of the given include files, in the
+// given order, as they are read concurrently. At most loadAhead files are read
+// (and held in memory) at any time.
+func (ui *CLI) readProfileMod(w io.Writer, name, path string, mod ...string) error {
 
-	each := make([]buf, len(mod))
-	work := sync.WaitGroup{}
-	work.Add(len(mod))
+	type buf struct {
+		data []byte
+		err  error
+	}
+
+	each := make([]chan buf, len(mod))
+	for i := range each {
+		each[i] = make(chan buf, 1)
+	}
+	ahead := make(chan struct{}, loadAhead)
+	done := make(chan struct{})
+	defer close(done)
+
+	// spawn a goroutine to read each file in a subdirectory, each filling their
+	// own separate buffers as they go, but no more than loadAhead at a time.
+	go func() {
+		for i, file := range mod {
+			select {
+			case ahead <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(fp string, ob chan<- buf) {
+				data, err := ui.readConfigFile(fp)
+				ob <- buf{data: data, err: err}
+			}(filepath.Join(path, file), each[i])
+		}
+	}()
 
-	// spawn a goroutine to read each file in a subdirectory simultaneously, each
-	// filling their own separate buffers as they go.
-	for i, file := range mod {
-		each[i] = buf{}
-		go func(wg *sync.WaitGroup, fp string, ob *buf) {
-			if bytes, err := ioutil.ReadFile(fp); err != nil {
-				ui.Log.Context().WithError(errors.Trace(err)).Warn("skipping file")
-			} else {
-				*ob = append(*ob, bytes...)
+	// now write each block in the right order as soon as it is read
+	for i, ch := range each {
+		b := <-ch
+		<-ahead
+		if b.err != nil {
+			if err := ui.skipFile(name, b.err); err != nil {
+				return err
 			}
-			wg.Done()
-		}(&work, filepath.Join(path, file), &each[i])
+		}
+		var env []byte
+		if ui.startup != nil {
+			env = append(env, ui.startup.Marker(name, mod[i])...)
+		}
+		env = append(env, shell.SourceBlock(name, mod[i], b.data)...)
+		if _, err := w.Write(env); err != nil {
+			return errors.Trace(err)
+		}
 	}
-	work.Wait()
+	return nil
+}
 
-	// now piece each block together in the right order
-	env := []byte{}
-	for _, b := range each {
-		env = append(env, b...)
+// skipFile logs a warning that a file of the given profile could not be read,
+// and returns nil so that the file is skipped. In strict mode, the error is
+// returned instead if the profile is being loaded, and the shell is not started.
+func (ui *CLI) skipFile(profile string, err error) error {
+	if ui.Param.Strict || ui.Config.Strict {
+		for _, name := range ui.Param.ProfileOrder() {
+			if name == profile {
+				return errors.Annotatef(err, "profile %q (strict)", profile)
+			}
+		}
 	}
-	return env
+	ui.Log.Context().WithError(errors.Trace(err)).Warn("skipping file")
+	return nil
 }
@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/juju/errors"
+)
+
+// command defines a command that gosh runs instead of starting a shell. Run
+// returns the exit status of the command, similar to CreateShell.
+type command struct {
+	config.Command
+	Run func(ui *CLI) (int, error)
+}
+
+// commands returns all commands in the order they are listed in usage.
+func commands() []*command {
+	return []*command{
+		activateCommand,
+		buildCommand,
+		bundleCommand,
+		checkCommand,
+		diffCommand,
+		historyCommand,
+		locateCommand,
+		reloadCommand,
+		tmuxCommand,
+		unloadCommand,
+		whoamiCommand,
+	}
+}
+
+// Commands returns the name, description, and flags of each command that gosh
+// runs instead of starting a shell.
+func Commands() []config.Command {
+	var cmd []config.Command
+	for _, c := range commands() {
+		cmd = append(cmd, c.Command)
+	}
+	return cmd
+}
+
+// RunCommand runs the command named in the user's command-line arguments, and
+// returns its exit status.
+func (ui *CLI) RunCommand() (int, error) {
+	for _, c := range commands() {
+		if c.Name == ui.Param.Command {
+			ui.Log.Context().
+				WithField("command", c.Name).
+				WithField("args", ui.Param.CommandArgs).
+				Debug("running command")
+			return c.Run(ui)
+		}
+	}
+	return 0, errors.NotFoundf("command %q", ui.Param.Command)
+}
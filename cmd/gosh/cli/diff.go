@@ -0,0 +1,189 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/environ"
+	"github.com/ardnew/gosh/cmd/gosh/session"
+	"github.com/ardnew/gosh/cmd/gosh/shell"
+	"github.com/juju/errors"
+)
+
+// diffFlags contains the flags of command "diff".
+var diffFlags struct {
+	against config.ProfileList
+	json    bool
+}
+
+var diffCommand = &command{
+	Command: config.Command{
+		Name: "diff",
+		Desc: "Print the environment variables, shell functions, and aliases added, removed, or changed by the profiles selected with -p, compared with the environment inherited by gosh (or with other profiles given with -against).",
+		Flag: func(fl *flag.FlagSet) {
+			fl.Var(&diffFlags.against, "against", "Compare with a shell that loaded `profile` (along with \"auto\") instead of no profiles; may be specified multiple times.")
+			fl.BoolVar(&diffFlags.json, "json", false, "Print the differences as a JSON object.")
+		},
+	},
+	Run: func(ui *CLI) (int, error) {
+		sh, ok := ui.Config.Shell[ui.Param.Shell]
+		if !ok {
+			return 0, errors.Errorf("undefined shell: %s", ui.Param.Shell)
+		}
+		d, err := ui.diff(&sh, diffFlags.against)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		if diffFlags.json {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return 0, errors.Trace(enc.Encode(d))
+		}
+		return 0, errors.Trace(d.write(os.Stdout))
+	},
+}
+
+// shellDiff is the difference between the state of two shells.
+type shellDiff struct {
+	Env      environ.Delta `json:"env"`
+	Function environ.Delta `json:"function"`
+	Alias    environ.Delta `json:"alias"`
+}
+
+// diff returns the difference between the state of the given shell after
+// loading the profiles selected by the user and after loading the profiles in
+// against, or no profiles at all if against is empty.
+func (ui *CLI) diff(sh *config.Shell, against []string) (*shellDiff, error) {
+	base := *ui.Param
+	base.Profiles = against
+	var before *shell.Snapshot
+	var err error
+	if len(against) > 0 {
+		before, err = ui.snapshotProfiles(sh, &base)
+	} else {
+		// the goshrc is empty, and only the inherited environment is exported
+		before, err = ui.snapshot(sh, &base, func(io.Writer, string) error { return nil }, nil)
+	}
+	if err != nil {
+		return nil, errors.Annotate(err, "base shell")
+	}
+	after, err := ui.snapshotProfiles(sh, ui.Param)
+	if err != nil {
+		return nil, errors.Annotate(err, "profile shell")
+	}
+	d := diffSnapshot(before, after)
+	ui.redactDelta(&d.Env)
+	return d, nil
+}
+
+// diffSnapshot returns the difference between the state of two shells.
+func diffSnapshot(before, after *shell.Snapshot) *shellDiff {
+	// variables that always differ between any two sessions of gosh
+	for _, key := range []string{"_", "GOSH_RCFILE", shell.BaseEnvironKey,
+		session.EnvID, session.EnvParent, session.EnvDepth, session.EnvStack,
+		session.EnvProfiles} {
+		delete(before.Env, key)
+		delete(after.Env, key)
+	}
+	return &shellDiff{
+		Env:      environ.Diff(before.Env, after.Env, environ.IsPathList),
+		Function: environ.Diff(before.Function, after.Function, nil),
+		Alias:    environ.Diff(before.Alias, after.Alias, nil),
+	}
+}
+
+// snapshotProfiles returns the state of the given shell after loading the
+// profiles of par, including the variables of their env files and secrets.
+func (ui *CLI) snapshotProfiles(sh *config.Shell, par *config.Parameters) (*shell.Snapshot, error) {
+	vars, secret, err := ui.readVars(par.ProfileOrder())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ui.Log.Redactor().Secret(secret...)
+	return ui.snapshot(sh, par, ui.readProfile(sh), vars)
+}
+
+// snapshot returns the state of the given shell after loading the profiles of
+// par from source, with the variables in vars added to its environment.
+func (ui *CLI) snapshot(sh *config.Shell, par *config.Parameters, source shell.ProfileSource, vars []string) (*shell.Snapshot, error) {
+	p := *par
+	p.ShellCommand, p.ShellArgs = shell.SnapshotCommand(shell.DialectOf(sh)), nil
+	ss, err := shell.Prepare(&p, ui.Log, ui.Config, sh, source, nil, vars)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer ss.Close()
+	return ss.Snapshot()
+}
+
+// redactDelta redacts the value of each sensitive variable in d.
+func (ui *CLI) redactDelta(d *environ.Delta) {
+	red := ui.Log.Redactor()
+	value := func(key, val string) string {
+		if val != "" && red.IsSensitive(key) {
+			return environ.Redacted
+		}
+		return red.Value(val)
+	}
+	for _, c := range [][]environ.Change{d.Added, d.Removed, d.Changed} {
+		for i := range c {
+			c[i].Old, c[i].New = value(c[i].Name, c[i].Old), value(c[i].Name, c[i].New)
+			for j := range c[i].Edit {
+				c[i].Edit[j].Elem = value(c[i].Name, c[i].Edit[j].Elem)
+			}
+		}
+	}
+}
+
+// write prints each difference on its own line, prefixed with "+" if added,
+// "-" if removed, or "~" if changed. Lists of paths are printed one element per
+// line, and the definitions of shell functions are not printed.
+func (d *shellDiff) write(out io.Writer) error {
+	var err error
+	printf := func(format string, arg ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(out, format, arg...)
+		}
+	}
+	section := func(title string, delta *environ.Delta, value bool) {
+		if delta.Empty() {
+			return
+		}
+		printf("%s\n", title)
+		show := func(val string) string {
+			if !value {
+				return ""
+			}
+			return "=" + strings.ReplaceAll(val, "\n", `\n`)
+		}
+		for _, c := range delta.Added {
+			printf("+ %s%s\n", c.Name, show(c.New))
+		}
+		for _, c := range delta.Removed {
+			printf("- %s%s\n", c.Name, show(c.Old))
+		}
+		for _, c := range delta.Changed {
+			if len(c.Edit) > 0 {
+				printf("~ %s\n", c.Name)
+				for _, e := range c.Edit {
+					printf("    %s %s\n", e.Op, e.Elem)
+				}
+				continue
+			}
+			if value {
+				printf("~ %s%s (was %q)\n", c.Name, show(c.New), c.Old)
+			} else {
+				printf("~ %s\n", c.Name)
+			}
+		}
+	}
+	section("ENVIRONMENT", &d.Env, true)
+	section("FUNCTIONS", &d.Function, false)
+	section("ALIASES", &d.Alias, true)
+	return errors.Trace(err)
+}
@@ -0,0 +1,212 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/shell"
+	"github.com/juju/errors"
+)
+
+// explainPlan describes everything resolved to start a shell.
+type explainPlan struct {
+	Shell      string           `json:"shell"`
+	Exec       string           `json:"exec"`
+	Path       string           `json:"path,omitempty"` // exec found in PATH
+	Launch     string           `json:"launch"`
+	Args       []string         `json:"args"`
+	Dir        string           `json:"dir"`
+	DirProfile string           `json:"dir_profile,omitempty"`
+	Goshrc     string           `json:"goshrc"`
+	Cached     bool             `json:"cached"`
+	Process    string           `json:"process,omitempty"`
+	Sandbox    string           `json:"sandbox,omitempty"`
+	Record     string           `json:"record,omitempty"`
+	PreHooks   []string         `json:"pre_hooks"`
+	PostHooks  []string         `json:"post_hooks"`
+	Profiles   []explainProfile `json:"profiles"`
+	Env        []string         `json:"env"`
+}
+
+// explainProfile describes a profile loaded by the shell, in load order.
+type explainProfile struct {
+	Name     string           `json:"name"`
+	Includes []explainInclude `json:"includes"`
+}
+
+// explainInclude describes an include file of a profile.
+type explainInclude struct {
+	File  string `json:"file"`
+	Size  int64  `json:"size"`
+	Error string `json:"error,omitempty"`
+}
+
+// explain prepares the given shell as it would be started, and prints the
+// resolved plan in the format selected with flag --explain instead of starting
+// it. No hooks or secret providers are run, and nothing is written other than
+// a temporary goshrc file that is removed before returning (never the cached
+// goshrc, even if the shell would use one).
+func (ui *CLI) explain(sh *config.Shell) (int, error) {
+
+	vars, secret, err := ui.readUnresolvedVars(ui.Param.ProfileOrder())
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	ui.Log.Redactor().Secret(secret...)
+
+	// the cache is only used to report whether the goshrc would be cached.
+	cached := ui.goshrcCache(sh, ui.Param) != nil
+	ss, err := shell.Prepare(ui.Param, ui.Log, ui.Config, sh, ui.readProfile(sh), nil, vars)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer ss.Close()
+
+	pre, post := ui.hooks(sh)
+	ss.Wait = len(post) > 0
+
+	plan := explainPlan{
+		Shell:      ui.Param.Shell,
+		Exec:       sh.Exec,
+		Launch:     ss.LaunchCached(cached),
+		Args:       ss.Args,
+		Dir:        ss.Dir,
+		DirProfile: ss.DirProfile,
+		Goshrc:     ss.RCFile,
+		Cached:     cached,
+		Record:     ss.Record,
+		PreHooks:   []string{},
+		PostHooks:  []string{},
+		Profiles:   []explainProfile{},
+		Env:        ui.Log.Redactor().Environ(ss.Env),
+	}
+	if path, err := exec.LookPath(sh.Exec); err != nil {
+		ui.Log.Context().
+			WithField("exec", sh.Exec).
+			WithError(err).
+			Warn("shell executable not found")
+	} else if abs, err := filepath.Abs(path); err == nil {
+		plan.Path = abs
+	}
+	for _, h := range pre {
+		plan.PreHooks = append(plan.PreHooks, h.Run)
+	}
+	for _, h := range post {
+		plan.PostHooks = append(plan.PostHooks, h.Run)
+	}
+	if plan.Dir == "" {
+		plan.Dir, _ = os.Getwd()
+	}
+	if attr := ss.Attr.String(); attr != "{}" {
+		plan.Process = attr
+	}
+	if ss.Box != nil {
+		plan.Sandbox = ss.Box.String()
+	}
+
+	root := filepath.Dir(ui.Param.ConfigPath)
+	for _, name := range ss.Profiles {
+		pro := explainProfile{Name: name, Includes: []explainInclude{}}
+		for _, file := range ui.Config.Profile[name].Include {
+			inc := explainInclude{File: filepath.Join(root, name, file), Size: -1}
+			if info, err := ui.statConfigFile(inc.File); err != nil {
+				inc.Error = err.Error()
+			} else {
+				inc.Size = info.Size()
+			}
+			pro.Includes = append(pro.Includes, inc)
+		}
+		plan.Profiles = append(plan.Profiles, pro)
+	}
+
+	if ui.Param.Explain == config.ExplainJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return 0, errors.Trace(enc.Encode(&plan))
+	}
+	return 0, errors.Trace(plan.write(os.Stdout))
+}
+
+// write writes the plan as text.
+func (plan *explainPlan) write(out io.Writer) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	path := plan.Path
+	if path == "" {
+		path = "(not found)"
+	}
+	fmt.Fprintf(tw, "shell\t%s\n", plan.Shell)
+	fmt.Fprintf(tw, "exec\t%s\n", plan.Exec)
+	fmt.Fprintf(tw, "path\t%s\n", path)
+	fmt.Fprintf(tw, "launch\t%s\n", plan.Launch)
+	fmt.Fprintf(tw, "args\t%s\n", quoteArgs(plan.Args))
+	if plan.DirProfile != "" {
+		fmt.Fprintf(tw, "dir\t%s (cwd of profile %q)\n", plan.Dir, plan.DirProfile)
+	} else {
+		fmt.Fprintf(tw, "dir\t%s\n", plan.Dir)
+	}
+	if plan.Cached {
+		fmt.Fprintf(tw, "goshrc\t%s (cached when started)\n", plan.Goshrc)
+	} else {
+		fmt.Fprintf(tw, "goshrc\t%s (removed once the shell exits)\n", plan.Goshrc)
+	}
+	if plan.Process != "" {
+		fmt.Fprintf(tw, "process\t%s\n", plan.Process)
+	}
+	if plan.Sandbox != "" {
+		fmt.Fprintf(tw, "sandbox\t%s\n", plan.Sandbox)
+	}
+	if plan.Record != "" {
+		fmt.Fprintf(tw, "record\t%s\n", plan.Record)
+	}
+	for _, run := range plan.PreHooks {
+		fmt.Fprintf(tw, "pre\t%s\n", run)
+	}
+	for _, run := range plan.PostHooks {
+		fmt.Fprintf(tw, "post\t%s\n", run)
+	}
+	if err := tw.Flush(); err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(tw, "PROFILE\tSIZE\tINCLUDE")
+	for _, pro := range plan.Profiles {
+		if len(pro.Includes) == 0 {
+			fmt.Fprintf(tw, "%s\t-\t-\n", pro.Name)
+		}
+		for _, inc := range pro.Includes {
+			if inc.Error != "" {
+				fmt.Fprintf(tw, "%s\t-\t%s (%s)\n", pro.Name, inc.File, inc.Error)
+			} else {
+				fmt.Fprintf(tw, "%s\t%dB\t%s\n", pro.Name, inc.Size, inc.File)
+			}
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := fmt.Fprintf(out, "\nENVIRONMENT\n%s\n", strings.Join(plan.Env, "\n")); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// quoteArgs returns the given arguments separated by spaces, quoting any that
+// are empty or contain whitespace or quotes.
+func quoteArgs(args []string) string {
+	q := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\") {
+			arg = strconv.Quote(arg)
+		}
+		q[i] = arg
+	}
+	return strings.Join(q, " ")
+}
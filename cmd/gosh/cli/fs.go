@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ardnew/gosh/cmd/gosh/environ"
+)
+
+// configName returns the name of the file at path in the configuration file
+// system (see config.Parameters.FS), and false if path is not within the
+// configuration directory.
+func (ui *CLI) configName(path string) (string, bool) {
+	rel, err := filepath.Rel(filepath.Dir(ui.Param.ConfigPath), path)
+	if err != nil {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	return rel, fs.ValidPath(rel)
+}
+
+// readConfigFile returns the content of the file at path, which is read from
+// the configuration file system if it is within the configuration directory,
+// or else from the host file system.
+func (ui *CLI) readConfigFile(path string) ([]byte, error) {
+	if name, ok := ui.configName(path); ok {
+		return fs.ReadFile(ui.Param.FS(), name)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// statConfigFile returns the file info of the file at path, which is found the
+// same as by readConfigFile.
+func (ui *CLI) statConfigFile(path string) (fs.FileInfo, error) {
+	if name, ok := ui.configName(path); ok {
+		return fs.Stat(ui.Param.FS(), name)
+	}
+	return os.Stat(path)
+}
+
+// parseEnvFile parses the dotenv file at path, which is read the same as by
+// readConfigFile (see environ.ParseFile).
+func (ui *CLI) parseEnvFile(path string, seed []string) ([]string, error) {
+	data, err := ui.readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return environ.Parse(path, data, seed)
+}
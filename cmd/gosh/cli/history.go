@@ -0,0 +1,214 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/session"
+	"github.com/ardnew/gosh/cmd/gosh/shell"
+	"github.com/ardnew/version"
+	"github.com/juju/errors"
+)
+
+// historyFlags contains the flags of command "history".
+var historyFlags struct {
+	profile  config.ProfileList
+	since    string
+	until    string
+	last     int
+	json     bool
+	relaunch string
+}
+
+var historyCommand = &command{
+	Command: config.Command{
+		Name: "history",
+		Desc: "List the sessions previously launched, or relaunch one of them with -relaunch.",
+		Flag: func(fl *flag.FlagSet) {
+			fl.Var(&historyFlags.profile, "profile", "Only list sessions that loaded `profile`; may be specified multiple times.")
+			fl.StringVar(&historyFlags.since, "since", "", "Only list sessions started at or after `time` (e.g., \"yesterday\", \"7d\", \"2006-01-02 15:04\").")
+			fl.StringVar(&historyFlags.until, "until", "", "Only list sessions started before `time`.")
+			fl.IntVar(&historyFlags.last, "last", 0, "Only list the last `n` sessions selected.")
+			fl.BoolVar(&historyFlags.json, "json", false, "List sessions as JSON lines, as recorded in the session log.")
+			fl.StringVar(&historyFlags.relaunch, "relaunch", "", "Start a new shell with the shell and profiles of session `id` (or \"last\" selected).")
+		},
+	},
+	Run: func(ui *CLI) (int, error) {
+		rec, err := ui.selectSessions()
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		if historyFlags.relaunch != "" {
+			return ui.relaunch(rec, historyFlags.relaunch)
+		}
+		if historyFlags.json {
+			enc := json.NewEncoder(os.Stdout)
+			for i := range rec {
+				if err := enc.Encode(&rec[i]); err != nil {
+					return 0, errors.Trace(err)
+				}
+			}
+			return 0, nil
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tSTART\tDURATION\tSTATUS\tSHELL\tPROFILES\tCWD\tCOMMAND")
+		for _, r := range rec {
+			// unknown if the shell replaced the gosh process
+			dur, status := "-", "-"
+			if d, ok := r.Duration(); ok {
+				dur = d.Round(time.Second).String()
+			}
+			if r.Status != nil {
+				status = strconv.Itoa(*r.Status)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				r.ID, r.Start.Local().Format("2006-01-02 15:04:05"),
+				dur, status, r.Shell,
+				strings.Join(r.Profiles, ","), r.Cwd, r.Command)
+		}
+		return 0, errors.Trace(tw.Flush())
+	},
+}
+
+// sessionLog returns the path to the session log.
+func (ui *CLI) sessionLog() string {
+	return filepath.Join(ui.Param.App.StateDir(), session.LogName)
+}
+
+// newSession returns the record of the given session with the given shell, or
+// nil if sessions are not logged.
+func (ui *CLI) newSession(sh *config.Shell, ss *shell.Session) *session.Record {
+	if ui.Config.Session.NoLog {
+		return nil
+	}
+	dir := ss.Dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	cfg, err := filepath.Abs(ui.Param.ConfigPath)
+	if err != nil {
+		cfg = ui.Param.ConfigPath
+	}
+	rec := &session.Record{
+		ID:       ss.ID,
+		Depth:    len(ss.Stack),
+		Profiles: ui.Param.ProfileOrder(),
+		Shell:    ui.Param.Shell,
+		Exec:     sh.Exec,
+		Config:   cfg,
+		Cwd:      dir,
+		Command:  ui.Param.ShellCommand,
+		Version:  version.String(),
+	}
+	if n := len(ss.Stack); n > 1 {
+		rec.Parent = ss.Stack[n-2].ID
+	}
+	return rec
+}
+
+// logSession appends the given record to the session log, if non-nil.
+func (ui *CLI) logSession(rec *session.Record) {
+	if rec == nil {
+		return
+	}
+	app := ui.Param.App
+	if err := session.Append(ui.sessionLog(), app.PermConfigFile, app.PermConfigDir, rec); err != nil {
+		ui.Log.Context().WithError(err).Warn("session not logged")
+	}
+}
+
+// selectSessions returns the records in the session log selected by the flags
+// of command "history".
+func (ui *CLI) selectSessions() ([]session.Record, error) {
+	now := time.Now()
+	filter := session.Filter{Profile: historyFlags.profile}
+	var err error
+	if historyFlags.since != "" {
+		if filter.Since, err = session.ParseTime(historyFlags.since, now); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if historyFlags.until != "" {
+		if filter.Until, err = session.ParseTime(historyFlags.until, now); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	all, err := session.Read(ui.sessionLog())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var rec []session.Record
+	for i := range all {
+		if filter.Match(&all[i]) {
+			rec = append(rec, all[i])
+		}
+	}
+	if n := historyFlags.last; n > 0 && n < len(rec) {
+		rec = rec[len(rec)-n:]
+	}
+	return rec, nil
+}
+
+// relaunch starts a new shell with the same configuration file, shell, and
+// profiles as the session with the given ID (or unique ID prefix), in the same
+// working directory if it still exists.
+func (ui *CLI) relaunch(rec []session.Record, id string) (int, error) {
+	var match []session.Record
+	if id == "last" {
+		if len(rec) > 0 {
+			match = rec[len(rec)-1:]
+		}
+	} else {
+		for _, r := range rec {
+			if strings.HasPrefix(r.ID, id) {
+				match = append(match, r)
+			}
+		}
+	}
+	switch len(match) {
+	case 0:
+		return 0, errors.NotFoundf("session %q", id)
+	case 1:
+	default:
+		return 0, errors.Errorf("ambiguous session %q (%d matches)", id, len(match))
+	}
+	r := match[0]
+
+	par := *ui.Param
+	par.Command, par.CommandArgs = "", nil
+	par.ShellCommand = ""
+	if r.Config != "" {
+		par.ConfigPath = r.Config
+	}
+	if r.Shell != "" {
+		par.Shell = r.Shell
+	}
+	par.Profiles = nil
+	for _, name := range r.Profiles {
+		if name != par.App.ReqProfileName {
+			par.Profiles = append(par.Profiles, name)
+		}
+	}
+	if err := os.Chdir(r.Cwd); err != nil {
+		ui.Log.Context().WithError(err).Warn("session directory not restored")
+	}
+	ui.Log.Context().
+		WithField("id", r.ID).
+		WithField("shell", par.Shell).
+		WithField("profiles", par.Profiles).
+		Info("relaunching session")
+
+	next, err := Start(&par)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	return next.CreateShell()
+}
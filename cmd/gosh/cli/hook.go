@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/juju/errors"
+)
+
+// Hook stages identify when a hook is run.
+const (
+	hookPre  = "pre"
+	hookPost = "post"
+)
+
+// hooks returns the pre-launch and post-exit hooks of the given shell and each
+// selected profile. Pre-launch hooks run in order of definition (shell first,
+// then profiles in load order), and post-exit hooks run in reverse order.
+func (ui *CLI) hooks(sh *config.Shell) (pre, post []config.Hook) {
+	pre = append(pre, sh.Hooks.Pre...)
+	stack := [][]config.Hook{sh.Hooks.Post}
+	for _, name := range ui.Param.ProfileOrder() {
+		if pro, ok := ui.Config.Profile[name]; ok {
+			pre = append(pre, pro.Hooks.Pre...)
+			stack = append(stack, pro.Hooks.Post)
+		}
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		post = append(post, stack[i]...)
+	}
+	return pre, post
+}
+
+// runHooks runs each of the given hooks with "sh -c" in directory dir, using
+// the environment env (formatted as os.Environ) with GOSH_HOOK set to stage.
+//
+// If a pre-launch hook fails without ignoring failure, the remaining hooks are
+// not run and its error is returned. Post-exit hooks always run.
+func (ui *CLI) runHooks(stage string, hooks []config.Hook, env []string, dir string) error {
+	for _, h := range hooks {
+		ctx := ui.Log.Context().
+			WithField("hook", stage).
+			WithField("run", h.Run)
+		cmd := exec.Command("sh", "-c", h.Run)
+		cmd.Env = append(append([]string{}, env...), "GOSH_HOOK="+stage)
+		cmd.Dir = dir
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		start := time.Now()
+		err := cmd.Run()
+		ctx = ctx.WithDuration(time.Since(start))
+		switch {
+		case err == nil:
+			ctx.Debug("hook succeeded")
+		case h.Ignore || stage == hookPost:
+			ctx.WithError(err).Warn("hook failed")
+		default:
+			ctx.WithError(err).Error("hook failed")
+			return errors.Annotatef(err, "%s hook %q", stage, h.Run)
+		}
+	}
+	return nil
+}
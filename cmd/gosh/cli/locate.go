@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/shell"
+	"github.com/juju/errors"
+)
+
+// locateFlags contains the flags of command "locate".
+var locateFlags struct {
+	rcfile string
+}
+
+// locateRef matches a goshrc line number as reported by various shells, e.g.,
+// "4817", "/tmp/goshrc-123:4817", or "/tmp/goshrc-123: line 4817: ...".
+var locateRef = regexp.MustCompile(`^(?:(.*?):\s*(?:line\s+)?)?(\d+)(?::.*)?$`)
+
+var locateCommand = &command{
+	Command: config.Command{
+		Name: "locate",
+		Desc: "Print the profile include file and line number from which each given goshrc line was copied.",
+		Flag: func(fl *flag.FlagSet) {
+			fl.StringVar(&locateFlags.rcfile, "rcfile", os.Getenv("GOSH_RCFILE"), "Locate lines in goshrc file `path` (default: the current session's goshrc).")
+		},
+	},
+	Run: func(ui *CLI) (int, error) {
+		if len(ui.Param.CommandArgs) == 0 {
+			return 0, errors.New("missing goshrc line number")
+		}
+		for _, arg := range ui.Param.CommandArgs {
+			loc, err := ui.locate(arg)
+			if err != nil {
+				return 0, errors.Trace(err)
+			}
+			fmt.Println(loc)
+		}
+		return 0, nil
+	},
+}
+
+// locate returns the location of the goshrc line given in ref, which may also
+// name the goshrc file (e.g., as reported in a shell's error message).
+func (ui *CLI) locate(ref string) (string, error) {
+	m := locateRef.FindStringSubmatch(strings.TrimSpace(ref))
+	if m == nil {
+		return "", errors.NotValidf("goshrc line %q", ref)
+	}
+	line, err := strconv.Atoi(m[2])
+	if err != nil {
+		return "", errors.NotValidf("goshrc line %q", ref)
+	}
+	path := locateFlags.rcfile
+	if m[1] != "" {
+		path = m[1]
+	}
+	if path == "" {
+		return "", errors.New("goshrc file unknown (GOSH_RCFILE undefined, see -rcfile)")
+	}
+	fh, err := os.Open(path)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer fh.Close()
+	loc, err := shell.Locate(fh, line)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if loc.Name == "" {
+		return fmt.Sprintf("%s:%d", path, loc.Line), nil
+	}
+	return loc.String(), nil
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"os"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/shell"
+	"github.com/juju/errors"
+)
+
+// startupCommand is the command run by the shell when profiling its startup,
+// which exits as soon as the goshrc file has been sourced.
+const startupCommand = "exit"
+
+// profileStartup runs the given shell non-interactively with a marker inserted
+// before each include file, and prints a report of the time spent sourcing each
+// include in the format selected with flag --profile-startup.
+func (ui *CLI) profileStartup(sh *config.Shell) (int, error) {
+
+	ui.startup = shell.NewStartup()
+	defer func() { ui.startup = nil }()
+
+	vars, secret, err := ui.readProfileVars()
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	ui.Log.Redactor().Secret(secret...)
+
+	par := *ui.Param
+	par.ShellCommand = startupCommand
+	// the markers are unique to each run, so the goshrc is never cached
+	ss, err := shell.Prepare(&par, ui.Log, ui.Config, sh, ui.readProfile(sh), nil, vars)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer ss.Close()
+
+	ui.Log.Context().
+		WithField("exec", sh.Exec).
+		WithField("format", ui.Param.ProfileStartup).
+		Info("profiling startup")
+
+	rep, err := ss.ProfileStartup(ui.startup)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	switch ui.Param.ProfileStartup {
+	case config.StartupJSON:
+		err = rep.WriteJSON(os.Stdout)
+	case config.StartupTrace:
+		err = rep.WriteTrace(os.Stdout)
+	default:
+		err = rep.WriteText(os.Stdout)
+	}
+	return 0, errors.Trace(err)
+}
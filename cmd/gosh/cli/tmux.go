@@ -0,0 +1,182 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/shell"
+	"github.com/juju/errors"
+)
+
+// tmuxFlags contains the flags of command "tmux".
+var tmuxFlags struct {
+	print bool
+}
+
+var tmuxCommand = &command{
+	Command: config.Command{
+		Name: "tmux",
+		Desc: "Create the tmux session defined by the selected profiles and attach to it, or attach to it if it already exists.",
+		Flag: func(fl *flag.FlagSet) {
+			fl.BoolVar(&tmuxFlags.print, "print", false, "Print the script that creates and attaches to the session instead of running it.")
+		},
+	},
+	Run: func(ui *CLI) (int, error) {
+		if _, ok := ui.Config.Shell[ui.Param.Shell]; !ok {
+			return 0, errors.Errorf("undefined shell: %s", ui.Param.Shell)
+		}
+		var script strings.Builder
+		if err := ui.tmux(&script); err != nil {
+			return 0, errors.Trace(err)
+		}
+		if tmuxFlags.print {
+			_, err := io.WriteString(os.Stdout, script.String())
+			return 0, errors.Trace(err)
+		}
+		cmd := exec.Command("sh", "-c", script.String())
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			if exit, ok := err.(*exec.ExitError); ok {
+				return exit.ExitCode(), nil
+			}
+			return 0, errors.Trace(err)
+		}
+		return 0, nil
+	},
+}
+
+// tmuxLayout returns the name of the last profile in load order that defines a
+// tmux layout, along with its layout.
+func (ui *CLI) tmuxLayout() (string, *config.Tmux, error) {
+	var name string
+	var layout *config.Tmux
+	for _, pro := range ui.definedProfiles(ui.Param) {
+		if t := ui.Config.Profile[pro].Tmux; t != nil {
+			name, layout = pro, t
+		}
+	}
+	if layout == nil {
+		return "", nil, errors.NotFoundf("tmux layout in profiles %q", ui.Param.ProfileOrder())
+	}
+	if len(layout.Windows) == 0 {
+		return "", nil, errors.NotValidf("tmux layout of profile %q without windows", name)
+	}
+	return name, layout, nil
+}
+
+// tmuxShell returns the command run in a tmux pane, which starts gosh with the
+// user's configuration file and shell, and the selected profiles followed by
+// those given.
+func (ui *CLI) tmuxShell(profiles []string) string {
+	q := shell.DialectPOSIX.Quote
+	exe, err := os.Executable()
+	if err != nil {
+		exe = ui.Param.App.PackageName
+	}
+	arg := []string{q(exe)}
+	if ui.Param.ConfigFS == nil {
+		// the executable's embedded configuration is used by default
+		arg = append(arg, "-f", q(ui.Param.ConfigPath))
+	}
+	arg = append(arg, "-e", q(ui.Param.Shell))
+	for _, name := range append(append([]string{}, ui.Param.Profiles...), profiles...) {
+		arg = append(arg, "-p", q(name))
+	}
+	if ui.Param.Strict {
+		arg = append(arg, "-strict")
+	}
+	return strings.Join(arg, " ")
+}
+
+// tmux writes a POSIX shell script that creates the tmux session defined by the
+// selected profiles, unless it already exists, and then attaches to it (or
+// switches to it, if run from within tmux).
+//
+// Each pane runs gosh with the selected profiles followed by the pane's own
+// profiles, so that each pane has its own goshrc, and the pane's command is
+// then typed into its shell.
+func (ui *CLI) tmux(out io.Writer) error {
+
+	name, layout, err := ui.tmuxLayout()
+	if err != nil {
+		return err
+	}
+	session := layout.Session
+	if session == "" {
+		session = name
+	}
+	bin := layout.Exec
+	if bin == "" {
+		bin = "tmux"
+	}
+
+	q := shell.DialectPOSIX.Quote
+	target := q("=" + session)
+	var b strings.Builder
+	fmt.Fprintf(&b, "#!/bin/sh\n# %s tmux layout of profile %q\n\n", ui.Param.App.PackageName, name)
+	fmt.Fprintf(&b, "tmux=%s\n", q(bin))
+	fmt.Fprintf(&b, "if ! \"$tmux\" has-session -t %s 2>/dev/null; then\n", target)
+	for w, win := range layout.Windows {
+		panes := win.Panes
+		if len(panes) == 0 {
+			panes = []config.TmuxPane{{}}
+		}
+		for p, pane := range panes {
+			var arg []string
+			switch {
+			case w == 0 && p == 0:
+				arg = []string{"new-session", "-d", "-s", q(session)}
+			case p == 0:
+				arg = []string{"new-window", "-t", q("=" + session + ":")}
+			default:
+				arg = []string{"split-window", "-t", `"$pane"`}
+				switch pane.Split {
+				case config.SplitHorizontal:
+					arg = append(arg, "-h")
+				case "", config.SplitVertical:
+					arg = append(arg, "-v")
+				default:
+					return errors.NotValidf("split %q of tmux window %d pane %d", pane.Split, w+1, p+1)
+				}
+				if pane.Size != "" {
+					arg = append(arg, "-l", q(pane.Size))
+				}
+			}
+			if p == 0 && win.Name != "" {
+				arg = append(arg, "-n", q(win.Name))
+			}
+			arg = append(arg, "-P", "-F", q("#{pane_id}"), q(ui.tmuxShell(pane.Profiles)))
+			fmt.Fprintf(&b, "\tpane=$(\"$tmux\" %s) || exit\n", strings.Join(arg, " "))
+			if w == 0 && p == 0 {
+				fmt.Fprintf(&b, "\tfirst=$pane\n")
+			}
+			if pane.Run != "" {
+				fmt.Fprintf(&b, "\t\"$tmux\" send-keys -t \"$pane\" %s Enter\n", q(pane.Run))
+			}
+		}
+		if win.Layout != "" {
+			fmt.Fprintf(&b, "\t\"$tmux\" select-layout -t \"$pane\" %s\n", q(win.Layout))
+		}
+	}
+	fmt.Fprintf(&b, "\t\"$tmux\" select-window -t \"$first\"\n")
+	fmt.Fprintf(&b, "\t\"$tmux\" select-pane -t \"$first\"\n")
+	fmt.Fprintf(&b, "fi\n")
+	fmt.Fprintf(&b, "if [ -n \"$TMUX\" ]; then\n")
+	fmt.Fprintf(&b, "\texec \"$tmux\" switch-client -t %s\n", target)
+	fmt.Fprintf(&b, "fi\n")
+	fmt.Fprintf(&b, "exec \"$tmux\" attach-session -t %s\n", target)
+
+	ui.Log.Context().
+		WithField("profile", name).
+		WithField("session", session).
+		WithField("windows", len(layout.Windows)).
+		Debug("tmux layout")
+
+	_, err = io.WriteString(out, b.String())
+	return errors.Trace(err)
+}
@@ -0,0 +1,187 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/environ"
+	"github.com/ardnew/gosh/cmd/gosh/shell"
+	"github.com/juju/errors"
+)
+
+var unloadCommand = &command{
+	Command: config.Command{
+		Name: "unload",
+		Desc: "Print a script that reverses the changes made by the profiles selected with -p to the environment, shell functions, and aliases of the current shell (e.g., eval \"$(gosh unload -p tinygo)\").",
+	},
+	Run: func(ui *CLI) (int, error) {
+		sh, ok := ui.Config.Shell[ui.Param.Shell]
+		if !ok {
+			return 0, errors.Errorf("undefined shell: %s", ui.Param.Shell)
+		}
+		if len(ui.Param.Profiles) == 0 {
+			return 0, errors.New("no profiles selected (use -p profile)")
+		}
+		return 0, errors.Trace(ui.unload(os.Stdout, &sh, ui.Param.Profiles))
+	},
+}
+
+// unload writes the script that reverses the changes made by the given profiles
+// to the current shell. The changes are found by comparing the state of a shell
+// that loaded all profiles of the current session with that of a shell that
+// loaded all but the given profiles.
+func (ui *CLI) unload(out io.Writer, sh *config.Shell, profiles []string) error {
+	loaded := ui.sessionProfiles()
+	drop := map[string]bool{}
+	for _, name := range profiles {
+		drop[name] = true
+	}
+	after, before := *ui.Param, *ui.Param
+	after.Profiles, before.Profiles = loaded, nil
+	// both shells inherit the environment of the current session before it
+	// loaded any profile, or else the changes already made by the profiles
+	// could not be found.
+	if path, ok := os.LookupEnv(shell.BaseEnvironKey); !ok {
+		ui.Log.Context().
+			WithField("key", shell.BaseEnvironKey).
+			Warn("base environment unknown (changes to existing variables may not be reversed)")
+	} else if base, err := shell.BaseEnviron(path, os.Environ()); err != nil {
+		ui.Log.Context().
+			WithField("key", shell.BaseEnvironKey).
+			WithError(err).
+			Warn("base environment unreadable (changes to existing variables may not be reversed)")
+	} else {
+		after.BaseEnviron, before.BaseEnviron = base, base
+	}
+	for _, name := range loaded {
+		if !drop[name] {
+			before.Profiles = append(before.Profiles, name)
+		}
+	}
+	for _, name := range profiles {
+		if !contains(loaded, name) {
+			ui.Log.Context().
+				WithField("profile", name).
+				WithField("loaded", loaded).
+				Warn("profile not loaded in current session")
+		}
+	}
+
+	prev, err := ui.snapshotProfiles(sh, &before)
+	if err != nil {
+		return errors.Annotate(err, "shell without profiles")
+	}
+	next, err := ui.snapshotProfiles(sh, &after)
+	if err != nil {
+		return errors.Annotate(err, "shell with profiles")
+	}
+	d := diffSnapshot(prev, next)
+
+	dialect := shell.DialectOf(sh)
+	var code []string
+	code = append(code, fmt.Sprintf("# %s unload: %s", ui.Param.App.PackageName, strings.Join(profiles, ", ")))
+	for _, c := range d.Env.Added {
+		code = append(code, dialect.Unset(c.Name))
+	}
+	for _, c := range d.Env.Removed {
+		code = append(code, dialect.Export(c.Name, c.Old))
+	}
+	for _, c := range d.Env.Changed {
+		val := c.Old
+		if len(c.Edit) > 0 {
+			if cur, ok := os.LookupEnv(c.Name); ok {
+				// keep any changes made to the list since the profiles were loaded
+				val = unloadPathList(cur, c.Edit)
+			}
+		}
+		code = append(code, dialect.Export(c.Name, val))
+	}
+	for _, c := range d.Function.Added {
+		code = append(code, dialect.Unfunction(c.Name))
+	}
+	for _, c := range append(d.Function.Removed, d.Function.Changed...) {
+		code = append(code, c.Old)
+	}
+	for _, c := range d.Alias.Added {
+		code = append(code, dialect.Unalias(c.Name))
+	}
+	for _, c := range append(d.Alias.Removed, d.Alias.Changed...) {
+		code = append(code, dialect.Alias(c.Name, c.Old))
+	}
+
+	var keep []string
+	for _, name := range before.ProfileOrder() {
+		if _, ok := ui.Config.Profile[name]; ok {
+			keep = append(keep, name)
+		}
+	}
+	code = append(code, ui.sessionExports(dialect, keep)...)
+
+	_, err = fmt.Fprintln(out, strings.Join(code, "\n"))
+	return errors.Trace(err)
+}
+
+// unloadPathList returns the list of paths cur with the given edits reversed.
+// If cur is unchanged since the edits were made, the list before the edits is
+// returned. Otherwise, each element added by the edits is removed from cur,
+// identified by its position among the occurrences of the same element (so
+// that an existing duplicate is kept), and each element removed by the edits
+// is appended if not already present. Elements only moved by the edits are
+// left in place.
+func unloadPathList(cur string, edit []environ.Edit) string {
+	var old, new []string
+	// the occurrences (by index among equal elements) added to the new list,
+	// and the number of each element added or removed (if negative).
+	added, count := map[string]map[int]bool{}, map[string]int{}
+	seen := map[string]int{}
+	for _, e := range edit {
+		switch e.Op {
+		case "+":
+			if added[e.Elem] == nil {
+				added[e.Elem] = map[int]bool{}
+			}
+			added[e.Elem][seen[e.Elem]] = true
+			new = append(new, e.Elem)
+			seen[e.Elem]++
+			count[e.Elem]++
+		case "-":
+			old = append(old, e.Elem)
+			count[e.Elem]--
+		default:
+			old, new = append(old, e.Elem), append(new, e.Elem)
+			seen[e.Elem]++
+		}
+	}
+	sep := string(os.PathListSeparator)
+	if cur == strings.Join(new, sep) {
+		return strings.Join(old, sep)
+	}
+	var list []string
+	seen = map[string]int{}
+	for _, elem := range environ.SplitPathList(cur) {
+		n := seen[elem]
+		seen[elem]++
+		if count[elem] > 0 && added[elem][n] {
+			continue
+		}
+		list = append(list, elem)
+	}
+	for _, e := range edit {
+		if e.Op == "-" && count[e.Elem] < 0 && !contains(list, e.Elem) {
+			list = append(list, e.Elem)
+		}
+	}
+	return strings.Join(list, sep)
+}
+
+func contains(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ardnew/gosh/cmd/gosh/environ"
+)
+
+func TestUnloadPathList(t *testing.T) {
+	list := func(elem ...string) string {
+		return strings.Join(elem, string(os.PathListSeparator))
+	}
+	split := func(val string) []string {
+		if val == "" {
+			return nil
+		}
+		return strings.Split(val, ",")
+	}
+	for _, tc := range []struct {
+		name     string
+		old, new string // the list before and after loading the profiles
+		cur      string // the current list
+		want     string
+	}{
+		{"unchanged", "/a,/b", "/x,/a,/b", "/x,/a,/b", "/a,/b"},
+		{"appended", "/a,/b", "/a,/b,/x", "/a,/b,/x", "/a,/b"},
+		{"removed", "/a,/x,/b", "/a,/b", "/a,/b", "/a,/x,/b"},
+		{"moved", "/a,/b", "/b,/a", "/b,/a", "/a,/b"},
+		{"duplicate prepended", "/a,/b", "/b,/a,/b", "/b,/a,/b", "/a,/b"},
+		{"duplicate appended", "/a,/b", "/a,/b,/a", "/a,/b,/a", "/a,/b"},
+		{"duplicate removed", "/a,/b,/a", "/a,/b", "/a,/b", "/a,/b,/a"},
+		{"changed since", "/a,/b", "/x,/a,/b", "/y,/x,/a,/b", "/y,/a,/b"},
+		{"changed since, duplicate prepended", "/a,/b", "/b,/a,/b", "/y,/b,/a,/b", "/y,/a,/b"},
+		{"changed since, duplicate appended", "/a,/b", "/a,/b,/a", "/y,/a,/b,/a", "/y,/a,/b"},
+		{"changed since, removed", "/a,/x,/b", "/a,/b", "/y,/a,/b", "/y,/a,/b,/x"},
+		{"changed since, moved", "/a,/b", "/b,/a", "/y,/b,/a", "/y,/b,/a"},
+		{"changed since, added removed", "/a,/b", "/x,/a,/b", "/a,/b", "/a,/b"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			edit := environ.DiffList(split(tc.old), split(tc.new))
+			cur := list(split(tc.cur)...)
+			if got, want := unloadPathList(cur, edit), list(split(tc.want)...); got != want {
+				t.Errorf("unloadPathList(%q, %v) = %q, want %q", cur, edit, got, want)
+			}
+		})
+	}
+}
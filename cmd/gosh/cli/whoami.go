@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/session"
+	"github.com/ardnew/gosh/cmd/gosh/shell"
+	"github.com/juju/errors"
+)
+
+// whoamiFlags contains the flags of command "whoami".
+var whoamiFlags struct {
+	json bool
+}
+
+var whoamiCommand = &command{
+	Command: config.Command{
+		Name: "whoami",
+		Desc: "Print the chain of gosh sessions enclosing the current shell, outermost first, and exit with non-zero status if not in a gosh session.",
+		Flag: func(fl *flag.FlagSet) {
+			fl.BoolVar(&whoamiFlags.json, "json", false, "Print the sessions as a JSON array.")
+		},
+	},
+	Run: func(ui *CLI) (int, error) {
+		st, err := session.ParseStack(os.LookupEnv)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		if whoamiFlags.json {
+			if st == nil {
+				st = session.Stack{}
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(st); err != nil {
+				return 0, errors.Trace(err)
+			}
+		} else if len(st) > 0 {
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "DEPTH\tSESSION\tSTART\tSHELL\tPROFILES")
+			for i, f := range st {
+				start := "-"
+				if !f.Start.IsZero() {
+					start = f.Start.Local().Format("2006-01-02 15:04:05")
+				}
+				id := f.ID
+				if id == "" {
+					id = "-"
+				}
+				fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n",
+					i+1, id, start, f.Shell, strings.Join(f.Profiles, ","))
+			}
+			if err := tw.Flush(); err != nil {
+				return 0, errors.Trace(err)
+			}
+		}
+		if len(st) == 0 {
+			return 1, nil
+		}
+		return 0, nil
+	},
+}
+
+// sessionStack returns the stack of sessions enclosing the current process, or
+// nil if it cannot be determined.
+func (ui *CLI) sessionStack() session.Stack {
+	st, err := session.ParseStack(os.LookupEnv)
+	if err != nil {
+		ui.Log.Context().WithError(err).Warn("enclosing sessions unknown")
+	}
+	return st
+}
+
+// sessionProfiles returns the profiles loaded by the current gosh session, or
+// only the required profile if not in a gosh session. The profiles selected by
+// the user are never included, since they are what is being loaded or unloaded.
+func (ui *CLI) sessionProfiles() []string {
+	if top := ui.sessionStack().Top(); top != nil {
+		return top.Profiles
+	}
+	return []string{ui.Param.App.ReqProfileName}
+}
+
+// sessionExports returns the statements that update the variables describing
+// the current session after its profiles were changed to those given.
+func (ui *CLI) sessionExports(d shell.Dialect, profiles []string) []string {
+	st := ui.sessionStack()
+	top := st.Top()
+	if top == nil {
+		return []string{d.Export(session.EnvProfiles, strings.Join(profiles, ","))}
+	}
+	top.Profiles = profiles
+	return []string{
+		d.Export(session.EnvProfiles, strings.Join(profiles, ",")),
+		d.Export(session.EnvStack, st.String()),
+	}
+}
+
+// checkDepth returns an error if a new shell would be nested within more than
+// the maximum number of sessions.
+func (ui *CLI) checkDepth() error {
+	max := ui.Config.Session.MaxDepth
+	if max <= 0 {
+		max = session.DefaultMaxDepth
+	}
+	if depth := session.Depth(os.LookupEnv) + 1; depth > max {
+		return errors.Errorf("session depth %d exceeds maximum %d (see \"%s whoami\")",
+			depth, max, ui.Param.App.PackageName)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/session"
+)
+
+func TestCheckDepth(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		max     int // configured maximum, or the default if zero
+		depth   int // sessions enclosing the new shell
+		wantErr bool
+	}{
+		{"outermost", 0, 0, false},
+		{"below default", 0, session.DefaultMaxDepth - 1, false},
+		{"at default", 0, session.DefaultMaxDepth, true},
+		{"below configured", 2, 1, false},
+		{"at configured", 2, 2, true},
+		{"above configured", 2, 3, true},
+		{"negative configured", -1, session.DefaultMaxDepth - 1, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var st session.Stack
+			for i := 0; i < tc.depth; i++ {
+				st = st.Push(session.Frame{ID: strconv.Itoa(i)})
+			}
+			t.Setenv(session.EnvStack, st.String())
+			ui := &CLI{
+				Param:  &config.Parameters{},
+				Config: &config.Config{Session: config.Session{MaxDepth: tc.max}},
+			}
+			if err := ui.checkDepth(); (err != nil) != tc.wantErr {
+				t.Errorf("checkDepth() error = %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -2,25 +2,114 @@ package config
 
 import (
 	"fmt"
-	"io/ioutil"
+	"io/fs"
 
 	"gopkg.in/yaml.v3"
 	// "github.com/juju/errors"
 )
 
 // Config represents the parameters to launch and configure the user shell.
+//
+// If Strict is true, the shell is not started if any include or env file of a
+// loaded profile cannot be read, same as the -strict flag.
 type Config struct {
 	Shell   Shells   `yaml:"shell"`
 	Profile Profiles `yaml:"profile"`
+	Redact  Redact   `yaml:"redact,omitempty"`
+	Session Session  `yaml:"session,omitempty"`
+	Cache   Cache    `yaml:"cache,omitempty"`
+	Strict  bool     `yaml:"strict,omitempty"`
+}
+
+// Redact defines the policy for hiding the values of sensitive environment
+// variables in all log messages and, if Goshrc is true, in the generated goshrc
+// printed with flag -d.
+//
+// Name contains glob patterns matched case-insensitively against variable
+// names, and Value contains regular expressions matched against variable
+// values. Both are added to a default set of patterns unless NoDefault is true.
+type Redact struct {
+	Name      []string `yaml:"name,omitempty"`
+	Value     []string `yaml:"value,omitempty"`
+	Goshrc    bool     `yaml:"goshrc,omitempty"`
+	NoDefault bool     `yaml:"nodefault,omitempty"`
+}
+
+// Session defines how gosh tracks the sessions it launches.
+//
+// Each session is appended to the session log in the gosh state directory when
+// its shell exits, unless NoLog is true. A shell is not started if it would be
+// nested within more than MaxDepth sessions (including itself; default 8), for
+// example when a profile of a login shell launched by gosh runs gosh again.
+type Session struct {
+	NoLog    bool `yaml:"nolog,omitempty"`
+	MaxDepth int  `yaml:"maxdepth,omitempty"`
+}
+
+// Cache defines how gosh reuses the goshrc files it generates.
+//
+// Each goshrc is cached in the gosh cache directory, keyed by a hash of the
+// configuration, the loaded profiles and their include files, and the shell,
+// and reused until any of those change, unless Disable is true. Cached files
+// not used within MaxAge (e.g., "72h"; default 7 days) are removed.
+type Cache struct {
+	Disable bool   `yaml:"disable,omitempty"`
+	MaxAge  string `yaml:"maxage,omitempty"`
 }
 
 // Shell defines the configuration attributes for a given shell.
 //
 // Exec is the absolute file path to the shell executable, and Flag defines the
-// positional arguments used with various invocation methods.
+// positional arguments used with various invocation methods. Dialect names the
+// shell's command language (e.g., "bash", "zsh", "fish") if it cannot be
+// inferred from the base name of Exec. Assemble is the default method used to
+// add each profile's include files to the goshrc (see Assemble).
 type Shell struct {
-	Exec string `yaml:"exec"`
-	Flag Flags  `yaml:"flag"`
+	Exec     string `yaml:"exec"`
+	Flag     Flags  `yaml:"flag"`
+	Dialect  string `yaml:"dialect,omitempty"`
+	Assemble string `yaml:"assemble,omitempty"`
+	Hooks    Hooks  `yaml:"hooks,omitempty"`
+}
+
+// Constant enumerated values of the Assemble attribute of shells and profiles,
+// which is the method used to add each include file to the goshrc.
+//
+// With AssembleConcat (the default), the content of each include file is copied
+// into the goshrc. With AssembleSource, the goshrc contains a statement that
+// sources each include file by its absolute path instead. The goshrc printed
+// with flag -d is always assembled with AssembleConcat.
+const (
+	AssembleConcat = "concat"
+	AssembleSource = "source"
+)
+
+// Hooks defines the commands gosh runs before starting the shell (Pre) and
+// after the shell exits (Post).
+type Hooks struct {
+	Pre  []Hook `yaml:"pre,omitempty"`
+	Post []Hook `yaml:"post,omitempty"`
+}
+
+// Hook defines a command run by gosh with "sh -c". If a pre-launch hook fails,
+// the shell is not started unless Ignore is true.
+//
+// A Hook may also be defined with a plain string, which is equivalent to only
+// defining Run.
+type Hook struct {
+	Run    string `yaml:"run"`
+	Ignore bool   `yaml:"ignore,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface so that a Hook can be
+// defined with either a plain string or a mapping.
+func (h *Hook) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*h = Hook{}
+		return node.Decode(&h.Run)
+	}
+	type hook Hook // avoid recursion
+	return node.Decode((*hook)(h))
 }
 
 // Flags defines the template argument lists passed to the shell.
@@ -34,19 +123,165 @@ type Flags struct {
 type Shells map[string]Shell
 
 // Profile defines the configuration attributes for a shell profile.
+//
+// EnvFile lists dotenv-formatted files, relative to the profile directory,
+// whose variables are added to the shell's environment. Environment variables
+// are resolved with the following precedence (lowest to highest):
+//
+//  1. the global env file in the gosh configuration directory
+//  2. the environment inherited from the current process
+//  3. each profile's EnvFile and then Secret, in profile load order
+//  4. each profile's Env, which is evaluated by the shell itself
+//
+// Record is the path to an asciinema recording of the session, relative to the
+// record directory in the gosh state directory. If Record names a directory
+// (i.e., it ends with a path separator or already exists as a directory), each
+// session is recorded to a new file in that directory.
+//
+// Assemble overrides the shell's Assemble method for the profile's includes.
+//
+// Tmux defines a layout of tmux windows and panes started by command "tmux".
 type Profile struct {
-	Cwd     string   `yaml:"cwd,omitempty"`
-	Env     []string `yaml:"env,omitempty"`
-	Inherit []string `yaml:"inherit,flow,omitempty"`
-	Include []string `yaml:"include,omitempty"`
+	Cwd      string   `yaml:"cwd,omitempty"`
+	Env      []string `yaml:"env,omitempty"`
+	EnvFile  []string `yaml:"envfile,omitempty"`
+	Secret   Secrets  `yaml:"secret,omitempty"`
+	History  *History `yaml:"history,omitempty"`
+	Process  *Process `yaml:"process,omitempty"`
+	Sandbox  *Sandbox `yaml:"sandbox,omitempty"`
+	Hooks    Hooks    `yaml:"hooks,omitempty"`
+	Record   string   `yaml:"record,omitempty"`
+	Assemble string   `yaml:"assemble,omitempty"`
+	Inherit  []string `yaml:"inherit,flow,omitempty"`
+	Include  []string `yaml:"include,omitempty"`
+	Tmux     *Tmux    `yaml:"tmux,omitempty"`
+}
+
+// History defines a shell command history isolated from that of other profiles.
+//
+// File is the path to the history file, relative to the history directory in
+// the gosh state directory, and defaults to the profile name. Size and FileSize
+// limit the number of commands kept in memory and in File, respectively. If
+// Merge is true, commands added to File are appended to the Global history file
+// (default: the shell's usual history file) when the shell exits.
+type History struct {
+	File     string `yaml:"file,omitempty"`
+	Size     int    `yaml:"size,omitempty"`
+	FileSize int    `yaml:"filesize,omitempty"`
+	Merge    bool   `yaml:"merge,omitempty"`
+	Global   string `yaml:"global,omitempty"`
+}
+
+// Process defines the attributes of the shell process, which are set before
+// the shell starts. Each attribute defined overrides the same attribute defined
+// by a profile loaded earlier.
+//
+// Umask is an octal file mode creation mask (e.g., "027"), Nice is the absolute
+// scheduling priority (niceness), and IOnice is the I/O scheduling class (one
+// of "idle", "best-effort", or "realtime", with optional ":level"; Linux only).
+// Limit maps resource names ("core", "cpu", "data", "fsize", "nofile", "stack",
+// "as") to limits of the form "soft[:hard]", each of which is either a number
+// (with optional binary suffix "k", "m", or "g") or "unlimited". Locale defines
+// both LANG and LC_ALL.
+type Process struct {
+	Umask  string            `yaml:"umask,omitempty"`
+	Nice   *int              `yaml:"nice,omitempty"`
+	IOnice string            `yaml:"ionice,omitempty"`
+	Limit  map[string]string `yaml:"limit,omitempty"`
+	Locale string            `yaml:"locale,omitempty"`
+}
+
+// Sandbox defines the isolation of the shell in unprivileged Linux user and
+// mount namespaces. The sandbox is enabled if any selected profile defines one,
+// and each attribute defined overrides the same attribute defined by a profile
+// loaded earlier, except that read-only paths are accumulated.
+//
+// Tmp mounts a private /tmp (default true), Home bind mounts the user's home
+// directory read-only, ReadOnly lists other paths to bind mount read-only (a
+// leading "~" refers to the home directory), and if Network is false, the shell
+// has no network access other than its own loopback interface.
+type Sandbox struct {
+	Tmp      *bool    `yaml:"tmp,omitempty"`
+	Home     bool     `yaml:"home,omitempty"`
+	ReadOnly []string `yaml:"readonly,omitempty"`
+	Network  *bool    `yaml:"network,omitempty"`
+}
+
+// Tmux defines a tmux session containing one or more windows, each of which is
+// split into one or more panes.
+//
+// Session is the name of the session (default: the profile name), and Exec is
+// the path to the tmux executable (default: "tmux" found in PATH).
+type Tmux struct {
+	Session string       `yaml:"session,omitempty"`
+	Exec    string       `yaml:"exec,omitempty"`
+	Windows []TmuxWindow `yaml:"windows"`
 }
 
+// TmuxWindow defines a window of a tmux session. Layout names one of tmux's
+// preset layouts (e.g., "tiled" or "main-vertical") applied once all of its
+// panes are created.
+type TmuxWindow struct {
+	Name   string     `yaml:"name,omitempty"`
+	Layout string     `yaml:"layout,omitempty"`
+	Panes  []TmuxPane `yaml:"panes,omitempty"`
+}
+
+// TmuxPane defines a pane of a tmux window, which runs a shell that loads the
+// selected profiles followed by Profiles, and then runs command Run (if any) in
+// that shell.
+//
+// Each pane other than the first of its window is created by splitting the
+// pane before it, either "horizontal" (side by side) or "vertical" (one above
+// the other; default), the same as tmux's split-window. Size is the size of
+// the new pane in lines or columns, or a percentage (e.g., "30%").
+//
+// A TmuxPane may also be defined with a plain string, which is equivalent to
+// only defining Run.
+type TmuxPane struct {
+	Split    string   `yaml:"split,omitempty"`
+	Size     string   `yaml:"size,omitempty"`
+	Run      string   `yaml:"run,omitempty"`
+	Profiles []string `yaml:"profiles,flow,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface so that a TmuxPane
+// can be defined with either a plain string or a mapping.
+func (p *TmuxPane) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*p = TmuxPane{}
+		return node.Decode(&p.Run)
+	}
+	type pane TmuxPane // avoid recursion
+	return node.Decode((*pane)(p))
+}
+
+// Constant enumerated values of the Split attribute of tmux panes.
+const (
+	SplitHorizontal = "horizontal"
+	SplitVertical   = "vertical"
+)
+
+// Secret defines the provider of a secret environment variable's value, which
+// is either the output of Command or the contents of File (relative to the
+// profile directory). If TTL is a positive duration (e.g., "8h"), the value is
+// cached on disk and reused until it expires.
+type Secret struct {
+	Command []string `yaml:"command,flow,omitempty"`
+	File    string   `yaml:"file,omitempty"`
+	TTL     string   `yaml:"ttl,omitempty"`
+}
+
+// Secrets maps names of environment variables to their secret providers.
+type Secrets map[string]Secret
+
 // Profiles maps names of profiles to their respective configuration attributes.
 type Profiles map[string]Profile
 
-// ParseFile parses the YAML configuration into our tidy struct.
-func ParseFile(filePath string) (*Config, error) {
-	data, err := ioutil.ReadFile(filePath)
+// ParseFile parses the YAML configuration file name in fsys into our tidy
+// struct.
+func ParseFile(fsys fs.FS, name string) (*Config, error) {
+	data, err := fs.ReadFile(fsys, name)
 	if err != nil {
 		return nil, err
 	}
@@ -58,6 +293,12 @@ func ParseFile(filePath string) (*Config, error) {
 	return &config, nil
 }
 
+// Marshal returns the YAML encoding of the configuration, which ParseFile parses
+// into an equivalent Config.
+func (cfg *Config) Marshal() ([]byte, error) {
+	return yaml.Marshal(cfg)
+}
+
 // String returns a string representation of the receiver Config.
 func (cfg Config) String() string {
 	return fmt.Sprintf("{Shell:%+v Profile:%+v}", cfg.Shell, cfg.Profile)
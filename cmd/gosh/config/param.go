@@ -4,13 +4,14 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 	"unicode"
 )
 
-// Default log handler when debug enabled (set in main.go:main).
+// Default log handler when debug enabled (set in app.Main).
 // These may also be overridden via environment variable GOSH_DEBUG.
 // However, priority is always given command-line flags (-o and -w).
 var (
@@ -37,6 +38,19 @@ type Parameters struct {
 	Profiles       ProfileList
 	LoginShell     bool
 	Interactive    bool
+	PseudoTerminal bool
+	RecordPath     string
+	Command        string
+	CommandArgs    []string
+	ProfileStartup StartupFormat
+	Explain        ExplainFormat
+	Strict         bool
+	Inherit        map[string][]string
+	BaseEnviron    []string
+	// ConfigFS contains the configuration file and profile directories, rooted
+	// at the directory of ConfigPath, if they are embedded in the executable
+	// instead of read from the host file system.
+	ConfigFS fs.FS
 }
 
 // AppProperties represents constants associated with the running applicatioo.
@@ -87,6 +101,29 @@ func (app *AppProperties) SourceEnvPath() string {
 	return filepath.Join(osConfigDir(), app.FileEnvName)
 }
 
+// CacheDir provides the path to the directory containing cached data that may
+// be deleted at any time without loss of configuration.
+func (app *AppProperties) CacheDir() string {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		// path to FreeDesktop's definition of $XDG_CACHE_HOME
+		const cacheDefault = ".cache"
+		cache = filepath.Join(app.HomeDir(), cacheDefault)
+	}
+	return filepath.Join(cache, app.PackageName)
+}
+
+// StateDir provides the path to the directory containing persistent data that
+// is not configuration, such as shell history.
+func (app *AppProperties) StateDir() string {
+	state, found := os.LookupEnv("XDG_STATE_HOME")
+	if !found || !filepath.IsAbs(state) {
+		// path to FreeDesktop's definition of $XDG_STATE_HOME
+		state = filepath.Join(app.HomeDir(), ".local", "state")
+	}
+	return filepath.Join(state, app.PackageName)
+}
+
 // HomeDir provides an absolute path to the user's home directory. Note that if
 // no $HOME dir can be determined, the current working dir is returned.
 func (app *AppProperties) HomeDir() string {
@@ -102,6 +139,54 @@ func (app *AppProperties) HomeDir() string {
 	return path
 }
 
+// Environ returns the environment inherited by the shell (formatted as
+// os.Environ): nil if orphaned, BaseEnviron if defined, or else the environment
+// of the current process.
+func (par *Parameters) Environ() []string {
+	if par.OrphanEnviron {
+		return nil
+	}
+	if par.BaseEnviron != nil {
+		return par.BaseEnviron
+	}
+	return os.Environ()
+}
+
+// FS returns the file system containing the configuration file and profile
+// directories, rooted at the directory of ConfigPath: ConfigFS if embedded, or
+// else the host file system.
+func (par *Parameters) FS() fs.FS {
+	if par.ConfigFS != nil {
+		return par.ConfigFS
+	}
+	return os.DirFS(filepath.Dir(par.ConfigPath))
+}
+
+// ProfileOrder returns the names of all profiles to load, in the order they are
+// loaded: the required profile followed by each profile selected by the user,
+// with duplicates removed. Each profile is preceded by the profiles it inherits
+// (recursively), unless they were already loaded.
+func (par *Parameters) ProfileOrder() []string {
+	seen := map[string]bool{}
+	order := []string{}
+	var visit func(name string)
+	visit = func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		// each inherited profile is loaded before the profile inheriting it
+		for _, inh := range par.Inherit[name] {
+			visit(inh)
+		}
+		order = append(order, name)
+	}
+	for _, name := range append([]string{par.App.ReqProfileName}, par.Profiles...) {
+		visit(name)
+	}
+	return order
+}
+
 // ProfileList represents gosh environments to load.
 type ProfileList []string
 
@@ -151,6 +236,79 @@ func (p *ProfileFileList) Set(value string) error {
 	return nil
 }
 
+// StartupFormat is the format of the report printed when profiling the time
+// spent in each include file during shell startup.
+type StartupFormat string
+
+// Constant enumerated values of type StartupFormat.
+const (
+	StartupText  StartupFormat = "text"
+	StartupJSON  StartupFormat = "json"
+	StartupTrace StartupFormat = "trace"
+)
+
+// String returns the receiver as a string.
+func (f *StartupFormat) String() string {
+	return string(*f)
+}
+
+// Set validates and assigns the given format to the receiver. Since the flag
+// may be given without a value (like a bool flag), "true" selects StartupText.
+func (f *StartupFormat) Set(value string) error {
+	switch v := StartupFormat(strings.ToLower(value)); v {
+	case "true":
+		*f = StartupText
+	case "false":
+		*f = ""
+	case StartupText, StartupJSON, StartupTrace:
+		*f = v
+	default:
+		return fmt.Errorf("invalid format: %q", value)
+	}
+	return nil
+}
+
+// IsBoolFlag allows the flag to be given without a value.
+func (f *StartupFormat) IsBoolFlag() bool {
+	return true
+}
+
+// ExplainFormat is the format of the execution plan printed instead of starting
+// a shell.
+type ExplainFormat string
+
+// Constant enumerated values of type ExplainFormat.
+const (
+	ExplainText ExplainFormat = "text"
+	ExplainJSON ExplainFormat = "json"
+)
+
+// String returns the receiver as a string.
+func (f *ExplainFormat) String() string {
+	return string(*f)
+}
+
+// Set validates and assigns the given format to the receiver. Since the flag
+// may be given without a value (like a bool flag), "true" selects ExplainText.
+func (f *ExplainFormat) Set(value string) error {
+	switch v := ExplainFormat(strings.ToLower(value)); v {
+	case "true":
+		*f = ExplainText
+	case "false":
+		*f = ""
+	case ExplainText, ExplainJSON:
+		*f = v
+	default:
+		return fmt.Errorf("invalid format: %q", value)
+	}
+	return nil
+}
+
+// IsBoolFlag allows the flag to be given without a value.
+func (f *ExplainFormat) IsBoolFlag() bool {
+	return true
+}
+
 // StartFlags contains attributes of the pre-defined command-line flags.
 type StartFlags struct {
 	Version        BoolFlag
@@ -167,6 +325,21 @@ type StartFlags struct {
   AddToProfiles  ProfileAddFlag
 	LoginShell     BoolFlag
 	Interactive    BoolFlag
+	PseudoTerminal BoolFlag
+	RecordPath     StringFlag
+	Command        []Command
+	ProfileStartup StartupFlag
+	Explain        ExplainFlag
+	Strict         BoolFlag
+}
+
+// Command contains the attributes of a command, named by the first positional
+// argument, that gosh runs instead of starting a shell. Flag registers any of
+// the command's own flags, which may follow its name along with global flags.
+type Command struct {
+	Name string
+	Desc string
+	Flag func(fl *flag.FlagSet)
 }
 
 // StringFlag contains the attributes of a string type command-line flag.
@@ -189,6 +362,18 @@ type ProfileFlag struct {
 	Desc string
 }
 
+// StartupFlag contains the attributes of a StartupFormat type command-line flag.
+type StartupFlag struct {
+	Flag string
+	Desc string
+}
+
+// ExplainFlag contains the attributes of an ExplainFormat type command-line flag.
+type ExplainFlag struct {
+	Flag string
+	Desc string
+}
+
 type ProfileAddFlag struct {
   Flag string
   Desc string
@@ -202,6 +387,16 @@ func (sf *StartFlags) Parse(app *AppProperties) (*Parameters, bool, error) {
   addToProfiles := ProfileFileList{}
 
 	fl := flag.NewFlagSet(app.PackageName, flag.ExitOnError)
+	fl.Usage = func() {
+		fmt.Fprintf(fl.Output(), "Usage of %s:\n", app.PackageName)
+		fl.PrintDefaults()
+		if len(sf.Command) > 0 {
+			fmt.Fprintf(fl.Output(), "\nCommands:\n")
+			for _, cmd := range sf.Command {
+				fmt.Fprintf(fl.Output(), "  %s\n    \t%s\n", cmd.Name, cmd.Desc)
+			}
+		}
+	}
 
 	fl.BoolVar(&param.Version, sf.Version.Flag, sf.Version.Preset, sf.Version.Desc)
 	fl.BoolVar(&param.ChangeLog, sf.ChangeLog.Flag, sf.ChangeLog.Preset, sf.ChangeLog.Desc)
@@ -217,6 +412,11 @@ func (sf *StartFlags) Parse(app *AppProperties) (*Parameters, bool, error) {
   fl.Var(&addToProfiles, sf.AddToProfiles.Flag, sf.AddToProfiles.Desc)
 	fl.BoolVar(&param.LoginShell, sf.LoginShell.Flag, sf.LoginShell.Preset, sf.LoginShell.Desc)
 	fl.BoolVar(&param.Interactive, sf.Interactive.Flag, sf.Interactive.Preset, sf.Interactive.Desc)
+	fl.BoolVar(&param.PseudoTerminal, sf.PseudoTerminal.Flag, sf.PseudoTerminal.Preset, sf.PseudoTerminal.Desc)
+	fl.StringVar(&param.RecordPath, sf.RecordPath.Flag, sf.RecordPath.Preset, sf.RecordPath.Desc)
+	fl.Var(&param.ProfileStartup, sf.ProfileStartup.Flag, sf.ProfileStartup.Desc)
+	fl.Var(&param.Explain, sf.Explain.Flag, sf.Explain.Desc)
+	fl.BoolVar(&param.Strict, sf.Strict.Flag, sf.Strict.Preset, sf.Strict.Desc)
 
 	argv := []string{}
 	parg := &argv
@@ -229,9 +429,22 @@ func (sf *StartFlags) Parse(app *AppProperties) (*Parameters, bool, error) {
 	}
 	fl.Parse(argv)
 
-	// Prepend the unhandled arguments preceding end of argument list "--" to
-	// those following it.
-	param.ShellArgs = append(fl.Args(), param.ShellArgs...)
+	// unless running a shell command, the first positional argument (before
+	// "--") may name a command, in which case all remaining arguments (before
+	// "--") are parsed with the command's flags. otherwise, all are passed on to
+	// the shell.
+	if cmd, ok := sf.command(fl.Arg(0)); ok && param.ShellCommand == "" {
+		param.Command = cmd.Name
+		if cmd.Flag != nil {
+			cmd.Flag(fl)
+		}
+		fl.Parse(fl.Args()[1:])
+		param.CommandArgs = fl.Args()
+	} else {
+		// Prepend the unhandled arguments preceding end of argument list "--" to
+		// those following it.
+		param.ShellArgs = append(fl.Args(), param.ShellArgs...)
+	}
 
 	// create a map of all flags actually provided by the user
 	type values []flag.Value
@@ -247,7 +460,7 @@ func (sf *StartFlags) Parse(app *AppProperties) (*Parameters, bool, error) {
 	_, pathGiven := given[sf.LogPath.Flag]
 
 	if param.DebugEnabled {
-		// DebugLogHandler and DebugLogWriter are set in main.go:main(), and they
+		// DebugLogHandler and DebugLogWriter are set in app.Main(), and they
 		// are the default handler/writer when only the debug flag (-g) is given.
 		if !handlerGiven {
 			param.LogHandler = DebugLogHandler
@@ -280,6 +493,16 @@ func (sf *StartFlags) Parse(app *AppProperties) (*Parameters, bool, error) {
 	return &param, fl.Parsed(), nil
 }
 
+// command returns the command with the given name, if defined.
+func (sf *StartFlags) command(name string) (Command, bool) {
+	for _, cmd := range sf.Command {
+		if name != "" && cmd.Name == name {
+			return cmd, true
+		}
+	}
+	return Command{}, false
+}
+
 func (par *Parameters) touch(filename ...string) bool {
   for _, pro := range filename {
     pp := filepath.Join(par.App.ConfigPath(), pro)
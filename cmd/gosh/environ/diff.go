@@ -0,0 +1,123 @@
+package environ
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// Change is the difference between the values of a single name (e.g., of a
+// variable, function, or alias) in two sets of definitions. Old is empty if the
+// name was added, and New is empty if it was removed.
+//
+// If the name is a list of paths (see IsPathList), Edit contains the element-
+// level difference between its old and new values.
+type Change struct {
+	Name string `json:"name"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+	Edit []Edit `json:"edit,omitempty"`
+}
+
+// Delta is the difference between two sets of definitions, with each of its
+// changes sorted by name.
+type Delta struct {
+	Added   []Change `json:"added,omitempty"`
+	Removed []Change `json:"removed,omitempty"`
+	Changed []Change `json:"changed,omitempty"`
+}
+
+// Empty returns true if there is no difference between the definitions.
+func (d *Delta) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Diff returns the difference between the definitions in old and new. If list
+// is non-nil, each changed name for which it returns true is compared element
+// by element as a list of paths separated by os.PathListSeparator.
+func Diff(old, new map[string]string, list func(name string) bool) Delta {
+	var d Delta
+	for name, val := range new {
+		prev, ok := old[name]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, Change{Name: name, New: val})
+		case prev != val:
+			c := Change{Name: name, Old: prev, New: val}
+			if list != nil && list(name) {
+				c.Edit = DiffList(SplitPathList(prev), SplitPathList(val))
+			}
+			d.Changed = append(d.Changed, c)
+		}
+	}
+	for name, val := range old {
+		if _, ok := new[name]; !ok {
+			d.Removed = append(d.Removed, Change{Name: name, Old: val})
+		}
+	}
+	for _, c := range [][]Change{d.Added, d.Removed, d.Changed} {
+		sort.Slice(c, func(i, j int) bool { return c[i].Name < c[j].Name })
+	}
+	return d
+}
+
+// IsPathList returns true if the variable named key is conventionally a list of
+// paths, such as PATH, MANPATH, LD_LIBRARY_PATH, or XDG_DATA_DIRS.
+func IsPathList(key string) bool {
+	return strings.HasSuffix(key, "PATH") || strings.HasSuffix(key, "_DIRS")
+}
+
+// SplitPathList returns the elements of a list of paths separated by
+// os.PathListSeparator, or nil if the list is empty.
+func SplitPathList(val string) []string {
+	if val == "" {
+		return nil
+	}
+	return strings.Split(val, string(os.PathListSeparator))
+}
+
+// Edit is a single element of the difference between two lists. Op is "+" if
+// Elem was added, "-" if removed, or " " if present in both lists.
+type Edit struct {
+	Op   string `json:"op"`
+	Elem string `json:"elem"`
+}
+
+// DiffList returns the edits that transform list old into list new, based on
+// their longest common subsequence, with each removed element preceding the
+// element added in its place.
+func DiffList(old, new []string) []Edit {
+	// lcs[i][j] is the length of the longest common subsequence of old[i:] and
+	// new[j:].
+	lcs := make([][]int, len(old)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(new)+1)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(new) - 1; j >= 0; j-- {
+			if old[i] == new[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var edit []Edit
+	i, j := 0, 0
+	for i < len(old) || j < len(new) {
+		switch {
+		case i < len(old) && j < len(new) && old[i] == new[j]:
+			edit = append(edit, Edit{Op: " ", Elem: old[i]})
+			i, j = i+1, j+1
+		case j == len(new) || (i < len(old) && lcs[i+1][j] >= lcs[i][j+1]):
+			edit = append(edit, Edit{Op: "-", Elem: old[i]})
+			i++
+		default:
+			edit = append(edit, Edit{Op: "+", Elem: new[j]})
+			j++
+		}
+	}
+	return edit
+}
@@ -0,0 +1,109 @@
+package environ
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func edits(s ...string) []Edit {
+	var e []Edit
+	for _, op := range s {
+		e = append(e, Edit{Op: op[:1], Elem: op[1:]})
+	}
+	return e
+}
+
+func TestDiffList(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		old, new []string
+		want     []Edit
+	}{
+		{"empty", nil, nil, nil},
+		{"same", []string{"a", "b"}, []string{"a", "b"}, edits(" a", " b")},
+		{"added", nil, []string{"a"}, edits("+a")},
+		{"removed", []string{"a"}, nil, edits("-a")},
+		{"prepend", []string{"a", "b"}, []string{"x", "a", "b"}, edits("+x", " a", " b")},
+		{"append", []string{"a"}, []string{"a", "x"}, edits(" a", "+x")},
+		{"remove middle", []string{"a", "b", "c"}, []string{"a", "c"}, edits(" a", "-b", " c")},
+		{"replace", []string{"a", "b"}, []string{"a", "x"}, edits(" a", "-b", "+x")},
+		{"moved", []string{"a", "b", "c"}, []string{"c", "a", "b"}, edits("+c", " a", " b", "-c")},
+		{"duplicate added", []string{"a", "b"}, []string{"b", "a", "b"}, edits("+b", " a", " b")},
+		{"duplicate removed", []string{"a", "b", "a"}, []string{"b", "a"}, edits("-a", " b", " a")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := DiffList(tc.old, tc.new); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("DiffList(%q, %q) = %v, want %v", tc.old, tc.new, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	list := func(elem ...string) string {
+		return strings.Join(elem, string(os.PathListSeparator))
+	}
+	old := map[string]string{
+		"A":     "1",
+		"B":     "2",
+		"MULTI": "line one\nline two",
+		"PATH":  list("/a", "/b"),
+		"SAME":  "x",
+	}
+	new := map[string]string{
+		"B":     "3",
+		"C":     "4",
+		"MULTI": "line one\nline three",
+		"PATH":  list("/x", "/a", "/b"),
+		"SAME":  "x",
+	}
+	want := Delta{
+		Added:   []Change{{Name: "C", New: "4"}},
+		Removed: []Change{{Name: "A", Old: "1"}},
+		Changed: []Change{
+			{Name: "B", Old: "2", New: "3"},
+			{Name: "MULTI", Old: "line one\nline two", New: "line one\nline three"},
+			{Name: "PATH", Old: old["PATH"], New: new["PATH"], Edit: edits("+/x", " /a", " /b")},
+		},
+	}
+	if got := Diff(old, new, IsPathList); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %+v, want %+v", got, want)
+	}
+	if got := Diff(old, old, IsPathList); !got.Empty() {
+		t.Errorf("Diff() of identical definitions = %+v, want empty", got)
+	}
+}
+
+func TestIsPathList(t *testing.T) {
+	for key, want := range map[string]bool{
+		"PATH":            true,
+		"MANPATH":         true,
+		"LD_LIBRARY_PATH": true,
+		"XDG_DATA_DIRS":   true,
+		"HOME":            false,
+		"PATHS_FILE":      false,
+	} {
+		if got := IsPathList(key); got != want {
+			t.Errorf("IsPathList(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestSplitPathList(t *testing.T) {
+	sep := string(os.PathListSeparator)
+	for _, tc := range []struct {
+		val  string
+		want []string
+	}{
+		{"", nil},
+		{"/a", []string{"/a"}},
+		{"/a" + sep + "/b", []string{"/a", "/b"}},
+		{"/a" + sep + sep + "/a", []string{"/a", "", "/a"}},
+	} {
+		if got := SplitPathList(tc.val); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("SplitPathList(%q) = %q, want %q", tc.val, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package environ
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/juju/errors"
+)
+
+// validKey matches the variable identifiers that are safe to pass through a
+// dotenv parser as seed definitions.
+var validKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// seedMark returns a prefix for the placeholder values of seed definitions that
+// does not occur anywhere in data.
+func seedMark(data []byte) string {
+	mark := "GOSH_SEED_"
+	for bytes.Contains(data, []byte(mark)) {
+		mark += "X"
+	}
+	return mark
+}
+
+// Split separates an environment variable formatted as "key=value" (as in the
+// elements of os.Environ) into its key and value.
+func Split(kv string) (key, val string) {
+	key, val, _ = strings.Cut(kv, "=")
+	return
+}
+
+// Lookup returns the value of the last variable named key in env, which is
+// formatted as os.Environ, and whether or not such a variable was found.
+func Lookup(env []string, key string) (string, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		if k, v := Split(env[i]); k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// Merge returns a copy of env with each of the given variables either replacing
+// the existing variable of the same name or, if undefined, appended. Both env
+// and vars are formatted as os.Environ.
+func Merge(env []string, vars ...string) []string {
+	merged := make([]string, len(env), len(env)+len(vars))
+	copy(merged, env)
+	index := map[string]int{}
+	for i, kv := range merged {
+		k, _ := Split(kv)
+		index[k] = i
+	}
+	for _, kv := range vars {
+		k, _ := Split(kv)
+		if i, ok := index[k]; ok {
+			merged[i] = kv
+		} else {
+			index[k] = len(merged)
+			merged = append(merged, kv)
+		}
+	}
+	return merged
+}
+
+// ParseFile parses the dotenv-formatted file at path and returns each variable
+// it defines, sorted by key and formatted as os.Environ.
+//
+// Variable references of the form $KEY or ${KEY} are expanded by godotenv. Any
+// variable defined in seed (formatted as os.Environ) may also be referenced, in
+// addition to those defined earlier in the file itself. Variables in seed are
+// not included in the result unless redefined by the file.
+func ParseFile(path string, seed []string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return Parse(path, data, seed)
+}
+
+// Parse is the same as ParseFile, but parses the given content of the file at
+// path instead of reading it.
+func Parse(path string, data []byte, seed []string) ([]string, error) {
+	// parse once without seed definitions to identify the keys defined in file
+	own, err := godotenv.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Annotate(err, path)
+	}
+	// and then again with seed definitions prepended so that they are available
+	// for variable expansion. godotenv cannot represent every value faithfully
+	// (e.g., those containing a double quote), so each seed variable is instead
+	// defined with a unique placeholder, which is replaced with its actual value
+	// after expansion.
+	var src bytes.Buffer
+	mark := seedMark(data)
+	subst := []string{}
+	for i, kv := range seed {
+		if k, v := Split(kv); validKey.MatchString(k) {
+			ph := fmt.Sprintf("%s%d_", mark, i)
+			fmt.Fprintf(&src, "%s=%s\n", k, ph)
+			subst = append(subst, ph, v)
+		}
+	}
+	src.Write(data)
+	all, err := godotenv.Parse(&src)
+	if err != nil {
+		return nil, errors.Annotate(err, path)
+	}
+	expand := strings.NewReplacer(subst...)
+	key := make([]string, 0, len(own))
+	for k := range own {
+		key = append(key, k)
+	}
+	sort.Strings(key)
+	vars := make([]string, len(key))
+	for i, k := range key {
+		vars[i] = fmt.Sprintf("%s=%s", k, expand.Replace(all[k]))
+	}
+	return vars, nil
+}
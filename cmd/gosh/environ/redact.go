@@ -0,0 +1,140 @@
+package environ
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// Redacted replaces the value of sensitive variables wherever they are
+// displayed.
+const Redacted = "<redacted>"
+
+// Default redaction patterns applied unless disabled by the user.
+var (
+	DefaultRedactName = []string{
+		"*TOKEN*", "*SECRET*", "*PASSWORD*", "*PASSWD*", "*PASSPHRASE*",
+		"*CREDENTIAL*", "*API_KEY*", "*APIKEY*", "*PRIVATE_KEY*",
+	}
+	DefaultRedactValue = []string{
+		`AKIA[0-9A-Z]{16}`,                   // AWS access key ID
+		`gh[pousr]_[A-Za-z0-9]{36,}`,         // GitHub token
+		`xox[abprs]-[A-Za-z0-9-]{10,}`,       // Slack token
+		`-----BEGIN [A-Z ]*PRIVATE KEY-----`, // PEM private key
+	}
+)
+
+// inlineVar matches variable definitions of the form "key=value" (optionally
+// quoted) embedded in arbitrary text.
+var inlineVar = regexp.MustCompile(`\b([A-Za-z_][A-Za-z0-9_]*)=("[^"]*"|'[^']*'|[^\s,\]]*)`)
+
+// Redactor hides the values of sensitive environment variables, identified
+// either by name (glob patterns matched case-insensitively) or by value
+// (regular expressions), as well as the values of any variable explicitly
+// marked secret.
+//
+// A Redactor is safe for concurrent use.
+type Redactor struct {
+	name   []string
+	value  []*regexp.Regexp
+	secret map[string]bool
+	mutex  sync.RWMutex
+}
+
+// NewRedactor constructs a new Redactor with the given name glob patterns and
+// value regular expressions.
+func NewRedactor(name, value []string) (*Redactor, error) {
+	r := Redactor{secret: map[string]bool{}}
+	for _, pat := range name {
+		pat = strings.ToUpper(pat)
+		if _, err := path.Match(pat, ""); err != nil {
+			return nil, errors.Annotatef(err, "redact name %q", pat)
+		}
+		r.name = append(r.name, pat)
+	}
+	for _, pat := range value {
+		re, err := regexp.Compile(pat)
+		if err != nil {
+			return nil, errors.Annotatef(err, "redact value %q", pat)
+		}
+		r.value = append(r.value, re)
+	}
+	return &r, nil
+}
+
+// Secret marks each given variable name as secret, which is always redacted.
+func (r *Redactor) Secret(key ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, k := range key {
+		r.secret[k] = true
+	}
+}
+
+// IsSecret reports whether variable key was marked secret.
+func (r *Redactor) IsSecret(key string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.secret[key]
+}
+
+// IsSensitive reports whether the value of variable key must be redacted.
+func (r *Redactor) IsSensitive(key string) bool {
+	if r.IsSecret(key) {
+		return true
+	}
+	up := strings.ToUpper(key)
+	for _, pat := range r.name {
+		if ok, _ := path.Match(pat, up); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Value returns val with every substring matching a value pattern redacted.
+func (r *Redactor) Value(val string) string {
+	for _, re := range r.value {
+		val = re.ReplaceAllLiteralString(val, Redacted)
+	}
+	return val
+}
+
+// Var returns a copy of the variable kv (formatted "key=value", optionally
+// prefixed with "export ") with its value redacted if necessary.
+func (r *Redactor) Var(kv string) string {
+	k, v, isVar := strings.Cut(kv, "=")
+	if !isVar {
+		return r.Value(kv)
+	}
+	if r.IsSensitive(strings.TrimPrefix(strings.TrimSpace(k), "export ")) {
+		return k + "=" + Redacted
+	}
+	return k + "=" + r.Value(v)
+}
+
+// Environ returns a copy of env (formatted as os.Environ) with the value of
+// each sensitive variable redacted.
+func (r *Redactor) Environ(env []string) []string {
+	red := make([]string, len(env))
+	for i, kv := range env {
+		red[i] = r.Var(kv)
+	}
+	return red
+}
+
+// Text returns a copy of the arbitrary text str with the values of sensitive
+// variables defined inline (e.g., "key=value") and all substrings matching a
+// value pattern redacted.
+func (r *Redactor) Text(str string) string {
+	str = inlineVar.ReplaceAllStringFunc(str, func(kv string) string {
+		if k, _, _ := strings.Cut(kv, "="); r.IsSensitive(k) {
+			return k + "=" + Redacted
+		}
+		return kv
+	})
+	return r.Value(str)
+}
@@ -0,0 +1,106 @@
+package environ
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// Secret resolves the value of an environment variable from either the output
+// of a local command or the contents of a file, so that it does not need to be
+// stored in plain text in any profile script.
+//
+// If Cache is a non-empty file path and TTL is positive, the resolved value is
+// written to Cache (with permissions PermFile, creating its parent directory
+// with permissions PermDir if necessary) and reused until TTL has elapsed
+// since it was last written.
+//
+// If ReadFile is non-nil, it is used to read File instead of ioutil.ReadFile.
+type Secret struct {
+	Key      string
+	Command  []string
+	File     string
+	Cache    string
+	TTL      time.Duration
+	PermFile os.FileMode
+	PermDir  os.FileMode
+	ReadFile func(path string) ([]byte, error)
+}
+
+// Resolve returns the value of the receiver Secret, and whether or not it was
+// read from the on-disk cache.
+func (sec *Secret) Resolve() (val string, cached bool, err error) {
+	if val, ok := sec.readCache(); ok {
+		return val, true, nil
+	}
+	var data []byte
+	switch {
+	case len(sec.Command) > 0:
+		var out bytes.Buffer
+		cmd := exec.Command(sec.Command[0], sec.Command[1:]...)
+		// commands like "pass" or "gpg" may need to prompt the user
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, &out, os.Stderr
+		if err = cmd.Run(); err != nil {
+			return "", false, errors.Annotatef(err, "secret %s", sec.Key)
+		}
+		data = out.Bytes()
+	case sec.File != "":
+		read := sec.ReadFile
+		if read == nil {
+			read = ioutil.ReadFile
+		}
+		if data, err = read(sec.File); err != nil {
+			return "", false, errors.Annotatef(err, "secret %s", sec.Key)
+		}
+	default:
+		return "", false, errors.Errorf("secret %s: no command or file", sec.Key)
+	}
+	val = strings.TrimRight(string(data), "\r\n")
+	if err = sec.writeCache(val); err != nil {
+		// an unwritable cache only costs us another lookup next time
+		err = nil
+	}
+	return val, false, nil
+}
+
+func (sec *Secret) readCache() (string, bool) {
+	if sec.Cache == "" || sec.TTL <= 0 {
+		return "", false
+	}
+	info, err := os.Stat(sec.Cache)
+	if err != nil || time.Since(info.ModTime()) > sec.TTL {
+		os.Remove(sec.Cache)
+		return "", false
+	}
+	data, err := ioutil.ReadFile(sec.Cache)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+func (sec *Secret) writeCache(val string) error {
+	if sec.Cache == "" || sec.TTL <= 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(sec.Cache), os.ModePerm&sec.PermDir); err != nil {
+		return errors.Trace(err)
+	}
+	fh, err := os.OpenFile(sec.Cache, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm&sec.PermFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer fh.Close()
+	// permissions given to OpenFile only apply when creating the file
+	if err := fh.Chmod(os.ModePerm & sec.PermFile); err != nil {
+		return errors.Trace(err)
+	}
+	_, err = fh.WriteString(val)
+	return errors.Trace(err)
+}
@@ -8,17 +8,30 @@ import (
 )
 
 // Code represents a program termination exit code.
+//
+// Each failure of gosh itself has a code in the range 120-125, which is
+// reserved so that it is distinct from the exit status of the shell (which is
+// propagated by gosh), including the statuses 126-127 of a command that cannot
+// be run and 128+n of a command killed by signal n (as with env(1)).
 type Code int
 
 // Constant enumerated values of type Code.
 const (
-	OK              Code = 0
-	FlagsNotParsed  Code = 1
-	CLINotStarted   Code = 2
-	ShellNotCreated Code = 3
-	InvalidFlags    Code = 4
+	OK                Code = 0
+	FlagsNotParsed    Code = 120
+	CLINotStarted     Code = 121
+	ShellNotCreated   Code = 122
+	InvalidFlags      Code = 123
+	SandboxNotCreated Code = 124
+	CommandFailed     Code = 125
 )
 
+// Propagate terminates program execution with the given exit status of the
+// shell process, without printing any message.
+func Propagate(status int) {
+	os.Exit(status)
+}
+
 // Halt terminates program execution with the receiver's exit code.
 func (c Code) Halt() {
 	c.HaltAnnotated(nil, "")
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/environ"
 
 	"github.com/apex/log"
 	"github.com/apex/log/handlers/cli"
@@ -20,6 +21,7 @@ import (
 type Handler struct {
 	id  Ident
 	ctx log.Interface
+	red *redactHandler
 }
 
 // NewHandler generates a logging interface based on user's given parameters.
@@ -27,16 +29,23 @@ func NewHandler(param *config.Parameters) *Handler {
 
 	id, _ := ParseIdent(param.LogHandler)
 
+	// all output handlers redact sensitive values, initially using only the
+	// default redaction policy until the user's configuration has been parsed.
+	red := &redactHandler{}
+	red.redact, _ = environ.NewRedactor(
+		environ.DefaultRedactName, environ.DefaultRedactValue)
+
 	switch id {
 	case LogNull:
-		log.SetHandler(discard.New())
+		red.out = discard.New()
 	case LogStandard:
-		log.SetHandler(cli.New(param.LogWriter))
+		red.out = cli.New(param.LogWriter)
 	case LogASCII:
-		log.SetHandler(text.New(param.LogWriter))
+		red.out = text.New(param.LogWriter)
 	case LogJSON:
-		log.SetHandler(json.New(param.LogWriter))
+		red.out = json.New(param.LogWriter)
 	}
+	log.SetHandler(red)
 
 	var ctx log.Interface
 	if param.DebugEnabled {
@@ -50,7 +59,17 @@ func NewHandler(param *config.Parameters) *Handler {
 		ctx = log.WithFields(log.Fields{})
 	}
 
-	return &Handler{id: id, ctx: ctx}
+	return &Handler{id: id, ctx: ctx, red: red}
+}
+
+// Redactor returns the redaction policy applied to all log messages.
+func (lh *Handler) Redactor() *environ.Redactor {
+	return lh.red.redact
+}
+
+// SetRedactor replaces the redaction policy applied to all log messages.
+func (lh *Handler) SetRedactor(r *environ.Redactor) {
+	lh.red.redact = r
 }
 
 // Context exposes the actual logging library to main. **Ta-da!!
@@ -0,0 +1,46 @@
+package log
+
+import (
+	"fmt"
+
+	"github.com/ardnew/gosh/cmd/gosh/environ"
+
+	"github.com/apex/log"
+)
+
+// redactHandler is a log handler that redacts sensitive values from each log
+// entry before passing it on to the actual output handler.
+type redactHandler struct {
+	out    log.Handler
+	redact *environ.Redactor
+}
+
+// HandleLog implements the apex/log.Handler interface.
+func (rh *redactHandler) HandleLog(e *log.Entry) error {
+	red := *e
+	red.Message = rh.redact.Text(e.Message)
+	red.Fields = make(log.Fields, len(e.Fields))
+	for k, v := range e.Fields {
+		red.Fields[k] = rh.field(v)
+	}
+	return rh.out.HandleLog(&red)
+}
+
+// field returns the redacted value of a log entry field. Field keys are never
+// matched against the redaction policy, since they name log metadata (e.g., the
+// name of a secret variable) rather than environment variables.
+func (rh *redactHandler) field(val interface{}) interface{} {
+	switch v := val.(type) {
+	case []string:
+		// lists of strings are typically environment variables
+		return rh.redact.Environ(v)
+	case string:
+		return rh.redact.Text(v)
+	case error:
+		return rh.redact.Text(v.Error())
+	case fmt.Stringer:
+		return rh.redact.Text(v.String())
+	default:
+		return val
+	}
+}
@@ -0,0 +1,222 @@
+package proc
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/juju/errors"
+)
+
+// Unlimited represents an infinite resource limit.
+const Unlimited uint64 = math.MaxUint64
+
+// Limit represents the soft and (optional) hard limit of a system resource.
+type Limit struct {
+	Soft    uint64
+	Hard    uint64
+	HasHard bool
+}
+
+// IOnice represents an I/O scheduling class and priority level.
+type IOnice struct {
+	Class int
+	Level int
+}
+
+// Constant enumerated values of the IOnice scheduling class.
+const (
+	IOniceNone       = 0
+	IOniceRealtime   = 1
+	IOniceBestEffort = 2
+	IOniceIdle       = 3
+)
+
+// Attr contains the attributes applied to the shell process before it starts.
+// They are applied to a process that then executes the shell (see Command), or
+// to gosh itself only when the shell replaces gosh. A nil field is left
+// unchanged.
+type Attr struct {
+	Umask  *int
+	Nice   *int
+	IOnice *IOnice
+	Limit  map[string]Limit
+	Locale string
+}
+
+// New constructs the process attributes defined by each of the given process
+// configurations. An attribute defined in a later configuration overrides the
+// same attribute defined in an earlier one.
+func New(pro ...*config.Process) (*Attr, error) {
+	attr := Attr{Limit: map[string]Limit{}}
+	for _, p := range pro {
+		if p == nil {
+			continue
+		}
+		if p.Umask != "" {
+			mask, err := strconv.ParseUint(p.Umask, 8, 32)
+			if err != nil || mask > 0o777 {
+				return nil, errors.Errorf("invalid umask: %q", p.Umask)
+			}
+			m := int(mask)
+			attr.Umask = &m
+		}
+		if p.Nice != nil {
+			n := *p.Nice
+			attr.Nice = &n
+		}
+		if p.IOnice != "" {
+			io, err := parseIOnice(p.IOnice)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			attr.IOnice = io
+		}
+		for name, val := range p.Limit {
+			lim, err := parseLimit(val)
+			if err != nil {
+				return nil, errors.Annotatef(err, "limit %s", name)
+			}
+			attr.Limit[strings.ToLower(name)] = lim
+		}
+		if p.Locale != "" {
+			attr.Locale = p.Locale
+		}
+	}
+	return &attr, nil
+}
+
+// IsEmpty reports whether the receiver does not change any attribute.
+func (a *Attr) IsEmpty() bool {
+	return a.Umask == nil && a.Nice == nil && a.IOnice == nil &&
+		len(a.Limit) == 0 && a.Locale == ""
+}
+
+// Environ returns the environment variables (formatted as os.Environ) defined
+// by the receiver's attributes.
+func (a *Attr) Environ() []string {
+	if a.Locale == "" {
+		return nil
+	}
+	return []string{"LANG=" + a.Locale, "LC_ALL=" + a.Locale}
+}
+
+// String returns a string representation of the receiver Attr.
+func (a *Attr) String() string {
+	attr := []string{}
+	if a.Umask != nil {
+		attr = append(attr, fmt.Sprintf("umask=%03o", *a.Umask))
+	}
+	if a.Nice != nil {
+		attr = append(attr, fmt.Sprintf("nice=%d", *a.Nice))
+	}
+	if a.IOnice != nil {
+		attr = append(attr, fmt.Sprintf("ionice=%d:%d", a.IOnice.Class, a.IOnice.Level))
+	}
+	for _, name := range a.limitNames() {
+		attr = append(attr, fmt.Sprintf("%s=%s", name, a.Limit[name]))
+	}
+	if a.Locale != "" {
+		attr = append(attr, fmt.Sprintf("locale=%s", a.Locale))
+	}
+	return fmt.Sprintf("{%s}", strings.Join(attr, " "))
+}
+
+// String returns a string representation of the receiver Limit.
+func (l Limit) String() string {
+	val := func(v uint64) string {
+		if v == Unlimited {
+			return "unlimited"
+		}
+		return strconv.FormatUint(v, 10)
+	}
+	if l.HasHard {
+		return val(l.Soft) + ":" + val(l.Hard)
+	}
+	return val(l.Soft)
+}
+
+func (a *Attr) limitNames() []string {
+	name := make([]string, 0, len(a.Limit))
+	for n := range a.Limit {
+		name = append(name, n)
+	}
+	sort.Strings(name)
+	return name
+}
+
+// parseLimit parses a resource limit of the form "soft[:hard]", where each is
+// either "unlimited" or a number with optional binary suffix "k", "m", or "g".
+func parseLimit(str string) (Limit, error) {
+	lhs, rhs, hasHard := strings.Cut(str, ":")
+	soft, err := parseLimitValue(lhs)
+	if err != nil {
+		return Limit{}, errors.Trace(err)
+	}
+	lim := Limit{Soft: soft, Hard: soft, HasHard: hasHard}
+	if hasHard {
+		if lim.Hard, err = parseLimitValue(rhs); err != nil {
+			return Limit{}, errors.Trace(err)
+		}
+		if lim.Soft > lim.Hard {
+			return Limit{}, errors.Errorf("soft limit exceeds hard limit: %q", str)
+		}
+	}
+	return lim, nil
+}
+
+func parseLimitValue(str string) (uint64, error) {
+	str = strings.ToLower(strings.TrimSpace(str))
+	switch str {
+	case "unlimited", "infinity", "inf":
+		return Unlimited, nil
+	}
+	mul := uint64(1)
+	if n := len(str); n > 0 {
+		switch str[n-1] {
+		case 'k':
+			mul = 1 << 10
+		case 'm':
+			mul = 1 << 20
+		case 'g':
+			mul = 1 << 30
+		}
+		if mul > 1 {
+			str = str[:n-1]
+		}
+	}
+	val, err := strconv.ParseUint(str, 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid limit: %q", str)
+	}
+	return val * mul, nil
+}
+
+// parseIOnice parses an I/O scheduling class of the form "class[:level]".
+func parseIOnice(str string) (*IOnice, error) {
+	lhs, rhs, hasLevel := strings.Cut(strings.ToLower(str), ":")
+	io := IOnice{}
+	switch strings.TrimSpace(lhs) {
+	case "none", "0":
+		io.Class = IOniceNone
+	case "realtime", "rt", "1":
+		io.Class = IOniceRealtime
+	case "best-effort", "besteffort", "be", "2":
+		io.Class = IOniceBestEffort
+	case "idle", "3":
+		io.Class = IOniceIdle
+	default:
+		return nil, errors.Errorf("invalid ionice class: %q", str)
+	}
+	if hasLevel {
+		level, err := strconv.Atoi(strings.TrimSpace(rhs))
+		if err != nil || level < 0 || level > 7 {
+			return nil, errors.Errorf("invalid ionice level: %q", str)
+		}
+		io.Level = level
+	}
+	return &io, nil
+}
@@ -0,0 +1,24 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package proc
+
+import (
+	"github.com/juju/errors"
+)
+
+// Apply changes the attributes of the current process to those defined by the
+// receiver, so that they are inherited by any process it creates or execs.
+//
+// Only the locale is supported on this platform.
+func (a *Attr) Apply() error {
+	return errors.Trace(a.check())
+}
+
+// check returns an error if any of the receiver's attributes is not supported.
+func (a *Attr) check() error {
+	if a.Umask != nil || a.Nice != nil || a.IOnice != nil || len(a.Limit) > 0 {
+		return errors.NotSupportedf("process attributes")
+	}
+	return nil
+}
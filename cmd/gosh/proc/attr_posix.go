@@ -0,0 +1,79 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package proc
+
+import (
+	"syscall"
+
+	"github.com/juju/errors"
+)
+
+// resource maps the names of resource limits to their identifiers.
+var resource = map[string]int{
+	"core":   syscall.RLIMIT_CORE,
+	"cpu":    syscall.RLIMIT_CPU,
+	"data":   syscall.RLIMIT_DATA,
+	"fsize":  syscall.RLIMIT_FSIZE,
+	"nofile": syscall.RLIMIT_NOFILE,
+	"stack":  syscall.RLIMIT_STACK,
+	"as":     syscall.RLIMIT_AS,
+}
+
+// Apply changes the attributes of the current process to those defined by the
+// receiver, so that they are inherited by any process it creates or execs.
+//
+// The niceness and I/O scheduling class are thread attributes on some systems,
+// so the caller must lock the current goroutine to its OS thread (with
+// runtime.LockOSThread) and start the new process from that same goroutine.
+func (a *Attr) Apply() error {
+	if err := a.check(); err != nil {
+		return err
+	}
+	for _, name := range a.limitNames() {
+		id := resource[name]
+		lim := a.Limit[name]
+		var cur syscall.Rlimit
+		if err := syscall.Getrlimit(id, &cur); err != nil {
+			return errors.Annotatef(err, "limit %s", name)
+		}
+		// only the soft limit is changed if no hard limit was given
+		hard := fromRlimit(cur.Max)
+		if lim.HasHard {
+			hard = lim.Hard
+		}
+		if lim.Soft > hard {
+			return errors.Errorf("limit %s: soft limit %s exceeds hard limit", name, lim)
+		}
+		set := newRlimit(lim.Soft, hard)
+		// use package syscall (instead of x/sys/unix) so that os/exec does not
+		// restore the original open file limit in child processes.
+		if err := syscall.Setrlimit(id, &set); err != nil {
+			return errors.Annotatef(err, "limit %s", name)
+		}
+	}
+	if a.Nice != nil {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, *a.Nice); err != nil {
+			return errors.Annotatef(err, "nice %d", *a.Nice)
+		}
+	}
+	if a.IOnice != nil {
+		if err := setIOnice(a.IOnice); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if a.Umask != nil {
+		syscall.Umask(*a.Umask)
+	}
+	return nil
+}
+
+// check returns an error if any of the receiver's attributes is not supported.
+func (a *Attr) check() error {
+	for _, name := range a.limitNames() {
+		if _, ok := resource[name]; !ok {
+			return errors.Errorf("unsupported resource limit: %s", name)
+		}
+	}
+	return nil
+}
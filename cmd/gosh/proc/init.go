@@ -0,0 +1,76 @@
+package proc
+
+import (
+	"encoding/json"
+	"os"
+	"runtime"
+	"syscall"
+
+	"github.com/juju/errors"
+)
+
+// EnvInitName is the environment variable used to pass the attributes and the
+// shell to the gosh process that applies them before executing the shell.
+const EnvInitName = "GOSH_PROC_INIT"
+
+// initSpec defines the attributes applied by the process started by Command,
+// and the shell it executes once they are applied.
+type initSpec struct {
+	Attr *Attr    `json:"attr"`
+	Exec string   `json:"exec"`
+	Args []string `json:"args"`
+}
+
+// Command returns the path, arguments, and environment of a process that
+// applies the receiver's attributes to itself and then executes the shell at
+// path with the given arguments and environment, so that the attributes are
+// applied to the shell only (and never to gosh). If the receiver does not
+// change any attribute of the process itself, the shell is returned unchanged.
+//
+// The process started is the current executable, which must call Init as early
+// as possible when IsInit reports true.
+func (a *Attr) Command(path string, args, env []string) (string, []string, []string, error) {
+	if a.Umask == nil && a.Nice == nil && a.IOnice == nil && len(a.Limit) == 0 {
+		return path, args, env, nil
+	}
+	if err := a.check(); err != nil {
+		return "", nil, nil, errors.Trace(err)
+	}
+	data, err := json.Marshal(&initSpec{Attr: a, Exec: path, Args: args})
+	if err != nil {
+		return "", nil, nil, errors.Trace(err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return "", nil, nil, errors.Trace(err)
+	}
+	env = append(append([]string{}, env...), EnvInitName+"="+string(data))
+	return self, []string{args[0]}, env, nil
+}
+
+// IsInit reports whether the current process was started to apply the process
+// attributes before executing the shell.
+func IsInit() bool {
+	_, ok := os.LookupEnv(EnvInitName)
+	return ok
+}
+
+// Init applies the attributes passed by Command to the current process and then
+// executes the shell, replacing the current process. It only returns if an
+// error occurred.
+func Init() error {
+	var spec initSpec
+	if err := json.Unmarshal([]byte(os.Getenv(EnvInitName)), &spec); err != nil {
+		return errors.Annotate(err, "process attributes")
+	}
+	os.Unsetenv(EnvInitName)
+	if spec.Attr == nil || len(spec.Args) == 0 {
+		return errors.New("process attributes: invalid spec")
+	}
+	// some attributes are per-thread, so the shell must start from this thread.
+	runtime.LockOSThread()
+	if err := spec.Attr.Apply(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(syscall.Exec(spec.Exec, spec.Args, os.Environ()))
+}
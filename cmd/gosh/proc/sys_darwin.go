@@ -0,0 +1,32 @@
+package proc
+
+import (
+	"math"
+	"syscall"
+
+	"github.com/juju/errors"
+)
+
+const rlimInfinity = math.MaxInt64
+
+func newRlimit(soft, hard uint64) syscall.Rlimit {
+	return syscall.Rlimit{Cur: toRlimit(soft), Max: toRlimit(hard)}
+}
+
+func toRlimit(v uint64) uint64 {
+	if v == Unlimited || v > rlimInfinity {
+		return rlimInfinity
+	}
+	return v
+}
+
+func fromRlimit(v uint64) uint64 {
+	if v == rlimInfinity {
+		return Unlimited
+	}
+	return v
+}
+
+func setIOnice(io *IOnice) error {
+	return errors.NotSupportedf("ionice")
+}
@@ -0,0 +1,42 @@
+package proc
+
+import (
+	"math"
+	"syscall"
+
+	"github.com/juju/errors"
+)
+
+const (
+	rlimInfinity     = math.MaxUint64
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+func newRlimit(soft, hard uint64) syscall.Rlimit {
+	return syscall.Rlimit{Cur: toRlimit(soft), Max: toRlimit(hard)}
+}
+
+func toRlimit(v uint64) uint64 {
+	if v == Unlimited {
+		return rlimInfinity
+	}
+	return v
+}
+
+func fromRlimit(v uint64) uint64 {
+	if v == rlimInfinity {
+		return Unlimited
+	}
+	return v
+}
+
+// setIOnice sets the I/O scheduling class of the current thread.
+func setIOnice(io *IOnice) error {
+	prio := uintptr(io.Class<<ioprioClassShift | io.Level)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, prio)
+	if errno != 0 {
+		return errors.Annotatef(errno, "ionice %d:%d", io.Class, io.Level)
+	}
+	return nil
+}
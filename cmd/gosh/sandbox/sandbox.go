@@ -0,0 +1,92 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/proc"
+	"github.com/juju/errors"
+)
+
+// EnvInitName is the environment variable used to pass the sandbox Spec to the
+// gosh process that initializes the sandbox before executing the shell.
+const EnvInitName = "GOSH_SANDBOX_INIT"
+
+// Spec defines the isolation of a shell process in unprivileged user and mount
+// namespaces, and the shell executed once they are initialized. If Attr is
+// non-nil, its process attributes are applied before executing the shell.
+type Spec struct {
+	Exec     string     `json:"exec"`
+	Args     []string   `json:"args"`
+	Keep     []string   `json:"keep"`
+	Tmp      bool       `json:"tmp"`
+	ReadOnly []string   `json:"readonly"`
+	Network  bool       `json:"network"`
+	Attr     *proc.Attr `json:"attr,omitempty"`
+}
+
+// New constructs the sandbox Spec defined by each of the given sandbox
+// configurations, or nil if no configuration is given. Attributes defined in a
+// later configuration override those defined in an earlier one, except that
+// read-only paths are accumulated.
+func New(home string, box ...*config.Sandbox) *Spec {
+	var spec *Spec
+	for _, b := range box {
+		if b == nil {
+			continue
+		}
+		if spec == nil {
+			spec = &Spec{Tmp: true, Network: true}
+		}
+		if b.Tmp != nil {
+			spec.Tmp = *b.Tmp
+		}
+		if b.Network != nil {
+			spec.Network = *b.Network
+		}
+		if b.Home {
+			spec.ReadOnly = append(spec.ReadOnly, home)
+		}
+		for _, path := range b.ReadOnly {
+			if path == "~" || strings.HasPrefix(path, "~/") {
+				path = filepath.Join(home, path[1:])
+			}
+			spec.ReadOnly = append(spec.ReadOnly, filepath.Clean(path))
+		}
+	}
+	return spec
+}
+
+// IsInit reports whether the current process was started to initialize the
+// sandbox before executing the shell.
+func IsInit() bool {
+	_, ok := os.LookupEnv(EnvInitName)
+	return ok
+}
+
+// String returns a string representation of the receiver Spec.
+func (s *Spec) String() string {
+	if s == nil {
+		return "{}"
+	}
+	b, _ := json.Marshal(struct {
+		Tmp      bool     `json:"tmp"`
+		ReadOnly []string `json:"readonly"`
+		Network  bool     `json:"network"`
+	}{s.Tmp, s.ReadOnly, s.Network})
+	return string(b)
+}
+
+// readSpec decodes the Spec passed to the current process and removes it from
+// the environment.
+func readSpec() (*Spec, error) {
+	var spec Spec
+	if err := json.Unmarshal([]byte(os.Getenv(EnvInitName)), &spec); err != nil {
+		return nil, errors.Annotate(err, "sandbox spec")
+	}
+	os.Unsetenv(EnvInitName)
+	return &spec, nil
+}
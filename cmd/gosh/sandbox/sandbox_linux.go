@@ -0,0 +1,148 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/juju/errors"
+)
+
+// mount flags that cannot be cleared when remounting a bind mount from within
+// an unprivileged user namespace.
+const lockedFlags = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC |
+	syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME
+
+// Command returns a command that executes the receiver's shell in new user and
+// mount (and, if network access is disabled, network) namespaces. The files in
+// keep are copied into the private /tmp, if any, at the same path.
+//
+// The command starts the current executable, which must call Init as early as
+// possible when IsInit reports true.
+func (s *Spec) Command(path string, args, env []string, dir string, keep ...string) (*exec.Cmd, error) {
+	spec := *s
+	spec.Exec, spec.Args, spec.Keep = path, args, keep
+	data, err := json.Marshal(&spec)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	flags := syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS
+	if !spec.Network {
+		flags |= syscall.CLONE_NEWNET
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	return &exec.Cmd{
+		Path:   self,
+		Args:   []string{args[0]},
+		Env:    append(env, EnvInitName+"="+string(data)),
+		Dir:    dir,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		SysProcAttr: &syscall.SysProcAttr{
+			Cloneflags:  uintptr(flags),
+			UidMappings: []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}},
+			GidMappings: []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}},
+		},
+	}, nil
+}
+
+// Init configures the namespaces created by Command and then executes the
+// shell, replacing the current process. It only returns if an error occurred.
+func Init() error {
+	spec, err := readSpec()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// do not propagate any of our mounts back to the parent namespace
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return errors.Annotate(err, "private mounts")
+	}
+	for _, path := range spec.ReadOnly {
+		if err := bindReadOnly(path); err != nil {
+			return errors.Annotatef(err, "read-only %s", path)
+		}
+	}
+	if spec.Tmp {
+		keep := map[string][]byte{}
+		for _, path := range spec.Keep {
+			if data, err := ioutil.ReadFile(path); err == nil {
+				keep[path] = data
+			}
+		}
+		if err := syscall.Mount("tmpfs", "/tmp", "tmpfs",
+			syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+			return errors.Annotate(err, "private /tmp")
+		}
+		for path, data := range keep {
+			if err := ioutil.WriteFile(path, data, 0o600); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	if !spec.Network {
+		if err := loopbackUp(); err != nil {
+			return errors.Annotate(err, "loopback")
+		}
+	}
+	if spec.Attr != nil {
+		// some attributes are per-thread, so the shell must start from this
+		// thread.
+		runtime.LockOSThread()
+		if err := spec.Attr.Apply(); err != nil {
+			return errors.Annotate(err, "process attributes")
+		}
+	}
+	return errors.Trace(syscall.Exec(spec.Exec, spec.Args, os.Environ()))
+}
+
+// bindReadOnly bind mounts path onto itself and then remounts it read-only,
+// preserving any mount flags that may not be cleared.
+func bindReadOnly(path string) error {
+	if err := syscall.Mount(path, path, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return errors.Trace(err)
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return errors.Trace(err)
+	}
+	// the ST_* flags reported by statfs equal their MS_* mount counterparts
+	flags := uintptr(int64(st.Flags)) & lockedFlags
+	return errors.Trace(syscall.Mount(path, path, "",
+		syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|flags, ""))
+}
+
+// loopbackUp enables the loopback interface of a new network namespace.
+func loopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer syscall.Close(fd)
+	var ifr struct {
+		name  [syscall.IFNAMSIZ]byte
+		flags uint16
+		_     [24 - 2]byte
+	}
+	copy(ifr.name[:], "lo")
+	ioctl := func(req uintptr) error {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(unsafe.Pointer(&ifr)))
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	}
+	if err := ioctl(syscall.SIOCGIFFLAGS); err != nil {
+		return errors.Trace(err)
+	}
+	ifr.flags |= syscall.IFF_UP
+	return errors.Trace(ioctl(syscall.SIOCSIFFLAGS))
+}
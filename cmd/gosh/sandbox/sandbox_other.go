@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+package sandbox
+
+import (
+	"os/exec"
+
+	"github.com/juju/errors"
+)
+
+// Command returns a command that executes the receiver's shell in a sandbox,
+// which is only supported on Linux.
+func (s *Spec) Command(path string, args, env []string, dir string, keep ...string) (*exec.Cmd, error) {
+	return nil, errors.NotSupportedf("sandbox")
+}
+
+// Init configures the sandbox created by Command and then executes the shell,
+// which is only supported on Linux.
+func Init() error {
+	if _, err := readSpec(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.NotSupportedf("sandbox")
+}
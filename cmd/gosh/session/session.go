@@ -0,0 +1,164 @@
+package session
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// LogName is the name of the session log file in the gosh state directory.
+const LogName = "session.jsonl"
+
+// Record describes a single shell launched by gosh, and is appended to the
+// session log (one JSON object per line) once the shell exits.
+//
+// If the shell replaced the gosh process, the record is instead appended when
+// the shell starts, and both End and Status are nil.
+type Record struct {
+	ID       string     `json:"id"`
+	Parent   string     `json:"parent,omitempty"`
+	Depth    int        `json:"depth,omitempty"`
+	Start    time.Time  `json:"start"`
+	End      *time.Time `json:"end,omitempty"`
+	Profiles []string   `json:"profiles"`
+	Shell    string     `json:"shell"`
+	Exec     string     `json:"exec"`
+	Config   string     `json:"config"`
+	Cwd      string     `json:"cwd"`
+	Command  string     `json:"command,omitempty"`
+	Status   *int       `json:"status,omitempty"`
+	Version  string     `json:"version"`
+}
+
+// NewID returns a new random session identifier.
+func NewID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Duration returns the time elapsed from the start to the end of the session,
+// and false if its end is unknown.
+func (r *Record) Duration() (time.Duration, bool) {
+	if r.End == nil {
+		return 0, false
+	}
+	return r.End.Sub(r.Start), true
+}
+
+// Append appends the given record to the session log at path, creating the log
+// (and its parent directories) with the given permissions if necessary.
+func Append(path string, perm, permDir os.FileMode, rec *Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm&permDir); err != nil {
+		return errors.Trace(err)
+	}
+	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, os.ModePerm&perm)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer fh.Close()
+	// a single write per record, so that concurrent sessions do not interleave
+	_, err = fh.Write(append(b, '\n'))
+	return errors.Trace(err)
+}
+
+// Read returns all records in the session log at path, in the order they were
+// appended. Lines that cannot be parsed are skipped. A log that does not exist
+// contains no records.
+func Read(path string) ([]Record, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Trace(err)
+	}
+	defer fh.Close()
+	var rec []Record
+	scan := bufio.NewScanner(fh)
+	scan.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scan.Scan() {
+		var r Record
+		if err := json.Unmarshal(scan.Bytes(), &r); err == nil {
+			rec = append(rec, r)
+		}
+	}
+	return rec, errors.Trace(scan.Err())
+}
+
+// Filter selects the records of sessions that loaded all of the given Profile
+// names and started within the given time range. Zero values match anything.
+type Filter struct {
+	Profile []string
+	Since   time.Time
+	Until   time.Time
+}
+
+// Match returns whether or not the given record is selected by the receiver.
+func (f *Filter) Match(r *Record) bool {
+	if !f.Since.IsZero() && r.Start.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !r.Start.Before(f.Until) {
+		return false
+	}
+	for _, want := range f.Profile {
+		found := false
+		for _, name := range r.Profiles {
+			if name == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseTime parses a point in time relative to now. It accepts "now", "today",
+// "yesterday", a duration before now (e.g., "36h", "7d"), or a date and time in
+// the local time zone (e.g., "2006-01-02", "2006-01-02 15:04", RFC 3339).
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch strings.ToLower(s) {
+	case "now":
+		return now, nil
+	case "today":
+		return midnight, nil
+	case "yesterday":
+		return midnight.AddDate(0, 0, -1), nil
+	}
+	if n := strings.TrimSuffix(s, "d"); n != s {
+		if days, err := strconv.Atoi(n); err == nil {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{
+		time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02",
+	} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.NotValidf("time %q", s)
+}
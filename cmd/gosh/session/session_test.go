@@ -0,0 +1,44 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	loc := time.FixedZone("test", -5*60*60)
+	now := time.Date(2021, 3, 4, 15, 30, 0, 0, loc)
+	for _, tc := range []struct {
+		in   string
+		want time.Time
+	}{
+		{"now", now},
+		{" NOW ", now},
+		{"today", time.Date(2021, 3, 4, 0, 0, 0, 0, loc)},
+		{"yesterday", time.Date(2021, 3, 3, 0, 0, 0, 0, loc)},
+		{"36h", now.Add(-36 * time.Hour)},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"7d", time.Date(2021, 2, 25, 15, 30, 0, 0, loc)},
+		{"0d", now},
+		{"2021-01-02", time.Date(2021, 1, 2, 0, 0, 0, 0, loc)},
+		{"2021-01-02 03:04", time.Date(2021, 1, 2, 3, 4, 0, 0, loc)},
+		{"2021-01-02T03:04", time.Date(2021, 1, 2, 3, 4, 0, 0, loc)},
+		{"2021-01-02 03:04:05", time.Date(2021, 1, 2, 3, 4, 5, 0, loc)},
+		{"2021-01-02T03:04:05Z", time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			got, err := ParseTime(tc.in, now)
+			if err != nil {
+				t.Fatalf("ParseTime(%q) error: %v", tc.in, err)
+			}
+			if !got.Equal(tc.want) {
+				t.Errorf("ParseTime(%q) = %v, want %v", tc.in, got, tc.want)
+			}
+		})
+	}
+	for _, in := range []string{"", "tomorrow", "d", "7days", "2021-13-01", "01/02/2021"} {
+		if got, err := ParseTime(in, now); err == nil {
+			t.Errorf("ParseTime(%q) = %v, want error", in, got)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package session
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// Names of the environment variables describing the nesting of gosh sessions,
+// which are defined in the environment of each shell launched by gosh.
+const (
+	EnvID       = "GOSH_SESSION" // ID of the current session
+	EnvParent   = "GOSH_PARENT"  // ID of the enclosing session, if any
+	EnvDepth    = "GOSH_DEPTH"   // number of nested sessions, 1 if outermost
+	EnvStack    = "GOSH_STACK"   // JSON array of each session, outermost first
+	EnvProfiles = "GOSH_PROFILE" // profiles of the current session
+)
+
+// DefaultMaxDepth is the maximum number of nested sessions, unless configured
+// otherwise.
+const DefaultMaxDepth = 8
+
+// Frame describes one of the nested sessions in a Stack.
+type Frame struct {
+	ID       string    `json:"id"`
+	Shell    string    `json:"shell"`
+	Profiles []string  `json:"profiles"`
+	Start    time.Time `json:"start"`
+}
+
+// Stack contains each of the nested sessions enclosing a shell, outermost first.
+type Stack []Frame
+
+// ParseStack returns the stack of sessions defined by the environment variables
+// found with lookup (e.g., os.LookupEnv). If the environment was defined by a
+// version of gosh that does not define EnvStack, the stack is derived from its
+// profiles, with each enclosing session's profiles in parentheses (e.g.,
+// "a,b(c,d)").
+func ParseStack(lookup func(key string) (string, bool)) (Stack, error) {
+	if val, ok := lookup(EnvStack); ok && val != "" {
+		var st Stack
+		if err := json.Unmarshal([]byte(val), &st); err != nil {
+			return nil, errors.Annotatef(err, "invalid %s", EnvStack)
+		}
+		return st, nil
+	}
+	if val, ok := lookup(EnvProfiles); ok && val != "" {
+		return parseLegacy(val), nil
+	}
+	return nil, nil
+}
+
+func parseLegacy(val string) Stack {
+	var outer Stack
+	if i := strings.IndexByte(val, '('); i >= 0 {
+		inner := strings.TrimSuffix(val[i+1:], ")")
+		val, outer = val[:i], parseLegacy(inner)
+	}
+	return append(outer, Frame{Profiles: splitProfiles(val)})
+}
+
+func splitProfiles(val string) []string {
+	var list []string
+	for _, name := range strings.Split(val, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			list = append(list, name)
+		}
+	}
+	return list
+}
+
+// Depth returns the number of nested sessions defined by the environment
+// variables found with lookup, or the value of EnvDepth if its stack cannot be
+// parsed.
+func Depth(lookup func(key string) (string, bool)) int {
+	if st, err := ParseStack(lookup); err == nil {
+		return len(st)
+	}
+	if val, ok := lookup(EnvDepth); ok {
+		if n, err := strconv.Atoi(val); err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+// Top returns the innermost session, or nil if the stack is empty.
+func (st Stack) Top() *Frame {
+	if len(st) == 0 {
+		return nil
+	}
+	return &st[len(st)-1]
+}
+
+// Push returns a copy of the stack with the given session added as innermost.
+func (st Stack) Push(f Frame) Stack {
+	return append(append(Stack{}, st...), f)
+}
+
+// String returns the stack formatted as a JSON array.
+func (st Stack) String() string {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return "[]"
+	}
+	return string(b)
+}
+
+// Environ returns the variables (formatted as os.Environ) that describe the
+// stack to a shell launched in its innermost session.
+func (st Stack) Environ() []string {
+	top := st.Top()
+	if top == nil {
+		return nil
+	}
+	parent := ""
+	if len(st) > 1 {
+		parent = st[len(st)-2].ID
+	}
+	return []string{
+		EnvID + "=" + top.ID,
+		EnvParent + "=" + parent,
+		EnvDepth + "=" + strconv.Itoa(len(st)),
+		EnvStack + "=" + st.String(),
+		EnvProfiles + "=" + strings.Join(top.Profiles, ","),
+	}
+}
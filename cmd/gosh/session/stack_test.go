@@ -0,0 +1,110 @@
+package session
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// env returns a lookup function (e.g., os.LookupEnv) of the given variables.
+func env(kv map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		val, ok := kv[key]
+		return val, ok
+	}
+}
+
+func TestParseStack(t *testing.T) {
+	start := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	stack := Stack{
+		{ID: "1", Shell: "bash", Profiles: []string{"auto"}, Start: start},
+		{ID: "2", Shell: "zsh", Profiles: []string{"auto", "tinygo"}, Start: start},
+	}
+	for _, tc := range []struct {
+		name    string
+		env     map[string]string
+		want    Stack
+		wantErr bool
+	}{
+		{name: "none", env: map[string]string{}},
+		{name: "empty", env: map[string]string{EnvStack: "", EnvProfiles: ""}},
+		{name: "stack", env: map[string]string{EnvStack: stack.String(), EnvProfiles: "x"}, want: stack},
+		{name: "invalid stack", env: map[string]string{EnvStack: "[{", EnvProfiles: "a"}, wantErr: true},
+		{
+			name: "legacy",
+			env:  map[string]string{EnvProfiles: "a, b"},
+			want: Stack{{Profiles: []string{"a", "b"}}},
+		},
+		{
+			name: "legacy nested",
+			env:  map[string]string{EnvProfiles: "a,b(c,d(e))"},
+			want: Stack{
+				{Profiles: []string{"e"}},
+				{Profiles: []string{"c", "d"}},
+				{Profiles: []string{"a", "b"}},
+			},
+		},
+		{
+			name: "legacy enclosing session without profiles",
+			env:  map[string]string{EnvProfiles: "a()"},
+			want: Stack{{}, {Profiles: []string{"a"}}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseStack(env(tc.env))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseStack() error = %v, want error %t", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ParseStack() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDepth(t *testing.T) {
+	frames := func(n int) string {
+		var st Stack
+		for i := 0; i < n; i++ {
+			st = st.Push(Frame{ID: strconv.Itoa(i)})
+		}
+		return st.String()
+	}
+	for _, tc := range []struct {
+		name string
+		env  map[string]string
+		want int
+	}{
+		{"none", map[string]string{}, 0},
+		{"stack", map[string]string{EnvStack: frames(3), EnvDepth: "7"}, 3},
+		{"max depth", map[string]string{EnvStack: frames(DefaultMaxDepth)}, DefaultMaxDepth},
+		{"legacy", map[string]string{EnvProfiles: "a(b(c))", EnvDepth: "7"}, 3},
+		{"invalid stack", map[string]string{EnvStack: "{", EnvDepth: "5"}, 5},
+		{"invalid stack and depth", map[string]string{EnvStack: "{", EnvDepth: "x"}, 0},
+		{"invalid stack without depth", map[string]string{EnvStack: "{"}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Depth(env(tc.env)); got != tc.want {
+				t.Errorf("Depth() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStackEnviron(t *testing.T) {
+	st := Stack{{ID: "1"}, {ID: "2", Profiles: []string{"a", "b"}}}
+	want := []string{
+		EnvID + "=2",
+		EnvParent + "=1",
+		EnvDepth + "=2",
+		EnvStack + "=" + st.String(),
+		EnvProfiles + "=a,b",
+	}
+	if got := st.Environ(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Environ() = %q, want %q", got, want)
+	}
+	if got := (Stack{}).Environ(); got != nil {
+		t.Errorf("Environ() of empty stack = %q, want nil", got)
+	}
+}
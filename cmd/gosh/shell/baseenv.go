@@ -0,0 +1,149 @@
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/ardnew/gosh/cmd/gosh/environ"
+	"github.com/juju/errors"
+)
+
+// BaseEnvironKey is the variable naming the file that records how the loaded
+// profiles changed the environment inherited by the shell.
+const BaseEnvironKey = "GOSH_BASEENV"
+
+// envReportKey is the variable that enables the shell to report its environment
+// once its goshrc has been sourced, which it writes to file descriptor
+// envReportFD (formatted as a Snapshot containing only its environment).
+const (
+	envReportKey = "GOSH_ENVREPORT"
+	envReportFD  = snapshotFD
+)
+
+// envReportRC returns the code appended to the goshrc that reports the
+// environment of a shell of the given dialect, if enabled.
+func envReportRC(d Dialect) []byte {
+	const env = `printf '\036env\n'; env; printf '\036end\n'`
+	if d == DialectFish {
+		// fish cannot close the file descriptor, so the report must end with a
+		// marker instead.
+		return []byte(fmt.Sprintf("\nif set -q %[1]s\n\tset -e %[1]s\n\tbegin; %[2]s; end >&%[3]d\nend\n",
+			envReportKey, env, envReportFD))
+	}
+	return []byte(fmt.Sprintf("\nif [ -n \"${%[1]s-}\" ]; then\n\tunset %[1]s\n\t{ %[2]s; } >&%[3]d\n\texec %[3]d>&-\nfi\n",
+		envReportKey, env, envReportFD))
+}
+
+// baseEnviron records the difference between the environment inherited by a
+// shell before any profile was loaded and its environment reported once its
+// goshrc has been sourced, to the file named by BaseEnvironKey.
+//
+// Only the variables changed by the loaded profiles are recorded: the original
+// value of each variable changed or removed, and the name of each variable
+// added. The values of sensitive variables are never recorded.
+type baseEnviron struct {
+	file *os.File
+	base []string
+	red  *environ.Redactor
+}
+
+// newBaseEnviron creates the file in directory dir (created with permissions
+// perm if it does not exist) that records the changes made by the loaded
+// profiles to the given environment.
+//
+// The directory must not be the temporary directory, which may be replaced in
+// a sandbox, and whose stale goshrc files are removed by RCCache.collect.
+func newBaseEnviron(dir string, perm os.FileMode, base []string, red *environ.Redactor) (*baseEnviron, error) {
+	if err := os.MkdirAll(dir, os.ModePerm&perm); err != nil {
+		return nil, errors.Trace(err)
+	}
+	file, err := tempFile(dir, "")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &baseEnviron{file: file, base: base, red: red}, nil
+}
+
+// record reads the environment reported by the shell from r and writes each
+// change from the base environment to the file. Each entry is terminated by a
+// NUL byte, as in /proc/<pid>/environ.
+func (be *baseEnviron) record(r io.Reader) error {
+	snap, err := parseSnapshot(r)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if snap.Env == nil {
+		return errors.New("no environment was reported")
+	}
+	delete(snap.Env, envReportKey)
+	base := map[string]string{}
+	for _, kv := range be.base {
+		k, v := environ.Split(kv)
+		base[k] = v
+	}
+	var entry []string
+	for k, v := range base {
+		if val, ok := snap.Env[k]; ok && val == v {
+			continue
+		}
+		if be.red.IsSensitive(k) || be.red.Value(v) != v {
+			continue
+		}
+		entry = append(entry, k+"="+v)
+	}
+	for k := range snap.Env {
+		if _, ok := base[k]; !ok {
+			entry = append(entry, k)
+		}
+	}
+	sort.Strings(entry)
+	var data []byte
+	for _, e := range entry {
+		data = append(append(data, e...), 0)
+	}
+	_, err = be.file.Write(data)
+	if cerr := be.file.Close(); err == nil {
+		err = cerr
+	}
+	return errors.Trace(err)
+}
+
+// close removes the file.
+func (be *baseEnviron) close() error {
+	be.file.Close()
+	return errors.Trace(os.Remove(be.file.Name()))
+}
+
+// BaseEnviron returns the environment env (formatted as os.Environ) with each
+// change recorded in the file at path by a prior session reversed, which is the
+// environment inherited by that session before it loaded any profile (except
+// for any sensitive variables it changed).
+func BaseEnviron(path string, env []string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(data) == 0 {
+		return nil, errors.Errorf("%s: empty (environment not yet reported by the shell)", path)
+	}
+	added := map[string]bool{}
+	var restore []string
+	for _, e := range bytes.Split(data, []byte{0}) {
+		if k, _, isVar := bytes.Cut(e, []byte("=")); isVar {
+			restore = append(restore, string(e))
+		} else if len(k) > 0 {
+			added[string(k)] = true
+		}
+	}
+	base := []string{}
+	for _, kv := range env {
+		if k, _ := environ.Split(kv); !added[k] {
+			base = append(base, kv)
+		}
+	}
+	return environ.Merge(base, restore...), nil
+}
@@ -0,0 +1,140 @@
+package shell
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/environ"
+)
+
+func TestBaseEnviron(t *testing.T) {
+	red, err := environ.NewRedactor(environ.DefaultRedactName, environ.DefaultRedactValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	red.Secret("DB_URL")
+	base := []string{
+		"HOME=/home/user",
+		"PATH=/bin:/usr/bin",
+		"EDITOR=vi",
+		"GITHUB_TOKEN=abc",
+		"DB_URL=postgres://localhost",
+		"KEY=ghp_" + strings.Repeat("x", 36),
+	}
+	be, err := newBaseEnviron(t.TempDir(), 0o700, base, red)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer be.close()
+
+	// the profiles change PATH, remove EDITOR, add GOPATH, and change each of
+	// the sensitive variables.
+	report := snapshotMark + "env\n" +
+		"HOME=/home/user\n" +
+		"PATH=/opt/go/bin:/bin:/usr/bin\n" +
+		"GOPATH=/opt/go\n" +
+		"GITHUB_TOKEN=xyz\n" +
+		"DB_URL=postgres://remote\n" +
+		"KEY=other\n" +
+		envReportKey + "=1\n" +
+		snapshotMark + "end\n" +
+		"output after the report\n"
+	if err := be.record(strings.NewReader(report)); err != nil {
+		t.Fatalf("record() error: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(be.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"abc", "postgres", "ghp_"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("recorded %q, which contains sensitive value %q", data, secret)
+		}
+	}
+
+	cur := []string{
+		"HOME=/home/user",
+		"PATH=/opt/go/bin:/bin:/usr/bin",
+		"GOPATH=/opt/go",
+		"GITHUB_TOKEN=xyz",
+		"DB_URL=postgres://remote",
+		"KEY=other",
+		"PWD=/tmp",
+	}
+	got, err := BaseEnviron(be.file.Name(), cur)
+	if err != nil {
+		t.Fatalf("BaseEnviron() error: %v", err)
+	}
+	want := []string{
+		"HOME=/home/user",
+		"PATH=/bin:/usr/bin",
+		"EDITOR=vi",
+		"GITHUB_TOKEN=xyz",
+		"DB_URL=postgres://remote",
+		"KEY=other",
+		"PWD=/tmp",
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("BaseEnviron() = %q, want %q", got, want)
+	}
+
+	if err := be.close(); err != nil {
+		t.Errorf("close() error: %v", err)
+	}
+	if _, err := os.Stat(be.file.Name()); !os.IsNotExist(err) {
+		t.Errorf("file not removed: %v", err)
+	}
+}
+
+func TestBaseEnvironNotReported(t *testing.T) {
+	red, err := environ.NewRedactor(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	be, err := newBaseEnviron(t.TempDir(), 0o700, nil, red)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer be.close()
+	if _, err := BaseEnviron(be.file.Name(), nil); err == nil {
+		t.Error("BaseEnviron() before the environment was reported: no error")
+	}
+	if err := be.record(strings.NewReader("the goshrc failed\n")); err == nil {
+		t.Error("record() without an env section: no error")
+	}
+}
+
+func TestSourcesGoshrc(t *testing.T) {
+	bash := &config.Shell{Exec: "/bin/bash"}
+	bash.Flag.Interactive = []string{"--rcfile", "__RCFILE__", "-i", "__ARGS__"}
+	bash.Flag.LoginShell = []string{"--rcfile", "__RCFILE__", "-l"}
+	tmux := &config.Shell{Exec: "/usr/bin/tmux"}
+	tmux.Flag.Interactive = []string{"new-session", "-A", "-s", "__PKG__", "gosh", "__ARGS__"}
+	tmux.Flag.LoginShell = []string{"-l", "new-session"}
+	for _, tc := range []struct {
+		name  string
+		shell *config.Shell
+		par   config.Parameters
+		want  bool
+	}{
+		{"interactive", bash, config.Parameters{Interactive: true}, true},
+		{"login", bash, config.Parameters{LoginShell: true}, true},
+		{"neither", bash, config.Parameters{}, false},
+		{"tmux interactive", tmux, config.Parameters{Interactive: true}, false},
+		{"tmux login", tmux, config.Parameters{LoginShell: true}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := sourcesGoshrc(&tc.par, tc.shell); got != tc.want {
+				t.Errorf("sourcesGoshrc() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
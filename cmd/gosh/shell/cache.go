@@ -0,0 +1,91 @@
+package shell
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/log"
+)
+
+// DefaultRCCacheMaxAge is the time after which a cached goshrc file that has
+// not been used is removed, unless configured otherwise.
+const DefaultRCCacheMaxAge = 7 * 24 * time.Hour
+
+// RCCache defines where a generated goshrc file is cached for reuse by later
+// sessions loading the same profiles.
+//
+// Key is a digest of everything (other than the shell) that determines the
+// content of the goshrc: the configuration, the loaded profiles, and their
+// include files. Each cached file is named by the hash of Key together with the
+// shell and any content appended by gosh, so that a change to any of them
+// results in a new goshrc file instead of modifying one that may be in use.
+type RCCache struct {
+	Dir     string
+	Key     []byte
+	MaxAge  time.Duration
+	PermDir os.FileMode
+}
+
+// path returns the path to the cached goshrc for the given shell and content
+// appended to the profiles.
+func (rc *RCCache) path(s *config.Shell, tail []byte) string {
+	h := sha256.New()
+	h.Write(rc.Key)
+	h.Write([]byte(strings.Join([]string{"", s.Exec, DialectOf(s).String(), ""}, "\x00")))
+	h.Write(tail)
+	return filepath.Join(rc.Dir, hex.EncodeToString(h.Sum(nil)))
+}
+
+// lookup returns true if the goshrc at path was cached by a prior session, and
+// updates its modification time so that it is not removed while in use.
+func (rc *RCCache) lookup(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return true
+}
+
+// collect removes each cached goshrc file that has not been used within MaxAge,
+// along with any goshrc files of the given prefix left behind in the temporary
+// directory by a session that never exited normally.
+func (rc *RCCache) collect(l *log.Handler, prefix string) {
+	maxAge := rc.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultRCCacheMaxAge
+	}
+	stale := append(
+		glob(filepath.Join(rc.Dir, "*")),
+		glob(filepath.Join(os.TempDir(), prefix+"*"))...)
+	removed := 0
+	for _, path := range stale {
+		info, err := os.Lstat(path)
+		if err != nil || !info.Mode().IsRegular() || time.Since(info.ModTime()) <= maxAge {
+			continue
+		}
+		if os.Remove(path) == nil {
+			removed++
+		}
+	}
+	if removed > 0 {
+		l.Context().
+			WithField("removed", removed).
+			WithField("maxage", maxAge).
+			Debug("collected stale goshrc files")
+	}
+}
+
+func glob(pattern string) []string {
+	match, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil
+	}
+	return match
+}
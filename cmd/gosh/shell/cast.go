@@ -0,0 +1,147 @@
+package shell
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/juju/errors"
+	"golang.org/x/term"
+)
+
+// castVersion is the version of the asciinema file format written by cast.
+const castVersion = 2
+
+// castHeader is the first line of an asciinema v2 recording.
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// cast writes an asciinema v2 recording of all output written to it, and of
+// each change to the terminal size reported via Resize.
+type cast struct {
+	mu    sync.Mutex
+	file  *os.File
+	start time.Time
+	part  []byte // incomplete UTF-8 sequence from the previous write
+}
+
+// recordPath returns the path to the asciinema recording of the session, or
+// the empty string if it is not recorded. The path given on the command line
+// overrides the Record path of the last profile in load order that defines it.
+func recordPath(p *config.Parameters, c *config.Config) string {
+	if p.RecordPath != "" {
+		return p.RecordPath
+	}
+	var name, path string
+	for _, n := range p.ProfileOrder() {
+		if pro, ok := c.Profile[n]; ok && pro.Record != "" {
+			name, path = n, pro.Record
+		}
+	}
+	if path == "" {
+		return ""
+	}
+	isDir := strings.HasSuffix(path, string(filepath.Separator))
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.App.StateDir(), "record", path)
+	}
+	if info, err := os.Stat(path); isDir || (err == nil && info.IsDir()) {
+		file := nonIdent.ReplaceAllString(name, "_") + "-" +
+			time.Now().Format("20060102-150405") + ".cast"
+		path = filepath.Join(path, file)
+	}
+	return path
+}
+
+// newCast creates the asciinema recording at path, writing its header with the
+// current size of gosh's terminal (or 80x24 if not a terminal).
+func newCast(path string, app *config.AppProperties, head castHeader) (*cast, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm&app.PermConfigDir); err != nil {
+		return nil, errors.Trace(err)
+	}
+	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm&app.PermConfigFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	c := &cast{file: fh, start: time.Now()}
+	head.Version = castVersion
+	head.Timestamp = c.start.Unix()
+	head.Width, head.Height = 80, 24
+	if w, h, err := term.GetSize(int(os.Stdin.Fd())); err == nil && w > 0 && h > 0 {
+		head.Width, head.Height = w, h
+	}
+	if err := c.writeLine(head); err != nil {
+		fh.Close()
+		return nil, errors.Trace(err)
+	}
+	return c, nil
+}
+
+// Write records b as output from the shell. Any incomplete UTF-8 sequence at
+// the end of b is held until the next Write, so that events are valid strings.
+func (c *cast) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data := append(c.part, b...)
+	n := len(data)
+	for i := 1; i <= utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[n-i]) {
+			if !utf8.FullRune(data[n-i:]) {
+				n -= i
+			}
+			break
+		}
+	}
+	c.part = append([]byte{}, data[n:]...)
+	if n > 0 {
+		if err := c.event("o", string(data[:n])); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Resize records a change to the size of the terminal.
+func (c *cast) Resize(cols, rows int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// Close writes any output held by Write and closes the recording.
+func (c *cast) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.part) > 0 {
+		c.event("o", string(c.part))
+		c.part = nil
+	}
+	return errors.Trace(c.file.Close())
+}
+
+func (c *cast) event(code, data string) error {
+	t := float64(time.Since(c.start).Microseconds()) / 1e6
+	return c.writeLine([]interface{}{t, code, data})
+}
+
+func (c *cast) writeLine(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = c.file.Write(append(b, '\n'))
+	return errors.Trace(err)
+}
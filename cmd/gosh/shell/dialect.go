@@ -0,0 +1,119 @@
+package shell
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+)
+
+// Dialect represents the command language of a shell, which determines the
+// syntax of any code generated by gosh.
+type Dialect int
+
+// Constant enumerated values of type Dialect.
+const (
+	DialectPOSIX Dialect = iota
+	DialectBash
+	DialectZsh
+	DialectFish
+)
+
+// DialectOf returns the Dialect of the given shell: its configured dialect if
+// defined, or else the dialect implied by the base name of its executable.
+func DialectOf(s *config.Shell) Dialect {
+	name := s.Dialect
+	if name == "" {
+		name = filepath.Base(s.Exec)
+	}
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "bash":
+		return DialectBash
+	case "zsh":
+		return DialectZsh
+	case "fish":
+		return DialectFish
+	default:
+		return DialectPOSIX
+	}
+}
+
+func (d Dialect) String() string {
+	if d < DialectPOSIX || d > DialectFish {
+		d = DialectPOSIX
+	}
+	return [...]string{"sh", "bash", "zsh", "fish"}[d]
+}
+
+// Quote returns str quoted as a single literal word.
+func (d Dialect) Quote(str string) string {
+	if d == DialectFish {
+		// fish only recognizes escaped backslashes and quotes in single quotes
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(str) + "'"
+	}
+	return "'" + strings.ReplaceAll(str, `'`, `'\''`) + "'"
+}
+
+// Export returns a statement that defines and exports variable key.
+func (d Dialect) Export(key, val string) string {
+	if d == DialectFish {
+		return fmt.Sprintf("set -gx %s %s", key, d.Quote(val))
+	}
+	return fmt.Sprintf("export %s=%s", key, d.Quote(val))
+}
+
+// Set returns a statement that defines (without exporting) variable key.
+func (d Dialect) Set(key, val string) string {
+	if d == DialectFish {
+		return fmt.Sprintf("set -g %s %s", key, d.Quote(val))
+	}
+	return fmt.Sprintf("%s=%s", key, d.Quote(val))
+}
+
+// Source returns a statement that reads and executes the file at path in the
+// current shell.
+func (d Dialect) Source(path string) string {
+	if d == DialectPOSIX {
+		return fmt.Sprintf(". %s", d.Quote(path))
+	}
+	return fmt.Sprintf("source %s", d.Quote(path))
+}
+
+// Unset returns a statement that removes variable key.
+func (d Dialect) Unset(key string) string {
+	if d == DialectFish {
+		return fmt.Sprintf("set -e %s", key)
+	}
+	return fmt.Sprintf("unset %s", key)
+}
+
+// Unfunction returns a statement that removes shell function name.
+func (d Dialect) Unfunction(name string) string {
+	switch d {
+	case DialectZsh:
+		return fmt.Sprintf("unfunction %s", name)
+	case DialectFish:
+		return fmt.Sprintf("functions -e %s", name)
+	default:
+		return fmt.Sprintf("unset -f %s", name)
+	}
+}
+
+// Alias returns a statement that defines alias name, whose definition def is
+// formatted as printed by the alias command of the dialect (i.e., quoted).
+func (d Dialect) Alias(name, def string) string {
+	if d == DialectFish {
+		return fmt.Sprintf("alias %s %s", name, def)
+	}
+	return fmt.Sprintf("alias %s=%s", name, def)
+}
+
+// Unalias returns a statement that removes alias name.
+func (d Dialect) Unalias(name string) string {
+	if d == DialectFish {
+		// aliases are defined as functions
+		return fmt.Sprintf("functions -e %s", name)
+	}
+	return fmt.Sprintf("unalias %s", name)
+}
@@ -0,0 +1,180 @@
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/environ"
+	"github.com/juju/errors"
+)
+
+// history represents the command history of a shell session isolated to the
+// history file of a single profile.
+type history struct {
+	conf    *config.History
+	dialect Dialect
+	name    string // history session name (fish only)
+	path    string // per-profile history file
+	global  string // history file to merge into on exit
+	before  []byte // content of path before the shell started
+	perm    os.FileMode
+}
+
+// mergeContext is the number of bytes at the end of the original history file
+// used to locate the commands appended to it if the file was truncated.
+const mergeContext = 1024
+
+// nonIdent matches the characters not allowed in a fish history session name.
+var nonIdent = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// newHistory returns the isolated history of the last profile in load order
+// that defines one, or nil if no selected profile does.
+func newHistory(p *config.Parameters, c *config.Config, d Dialect, env []string) *history {
+	var h *history
+	for _, name := range p.ProfileOrder() {
+		pro, ok := c.Profile[name]
+		if !ok || pro.History == nil {
+			continue
+		}
+		h = &history{conf: pro.History, dialect: d}
+		h.name = p.App.PackageName + "_" + nonIdent.ReplaceAllString(name, "_")
+		h.path = pro.History.File
+		if h.path == "" {
+			h.path = name
+		}
+		h.global = pro.History.Global
+	}
+	if h == nil {
+		return nil
+	}
+	home := p.App.HomeDir()
+	data, found := os.LookupEnv("XDG_DATA_HOME")
+	if !found || !filepath.IsAbs(data) {
+		data = filepath.Join(home, ".local", "share")
+	}
+	if d == DialectFish {
+		// fish only allows us to name the history session, not the file path.
+		h.path = filepath.Join(data, "fish", h.name+"_history")
+	} else if !filepath.IsAbs(h.path) {
+		h.path = filepath.Join(p.App.StateDir(), "history", h.path)
+	}
+	if h.global == "" {
+		if file, ok := environ.Lookup(env, "HISTFILE"); ok && d != DialectFish {
+			h.global = file
+		} else {
+			switch d {
+			case DialectBash:
+				h.global = filepath.Join(home, ".bash_history")
+			case DialectZsh:
+				h.global = filepath.Join(home, ".zsh_history")
+			case DialectFish:
+				h.global = filepath.Join(data, "fish", "fish_history")
+			default:
+				h.global = filepath.Join(home, ".sh_history")
+			}
+		}
+	}
+	return h
+}
+
+// prepare creates the history file, along with any missing parent directories,
+// using the application's configuration permissions, and records its content
+// so that commands added by the shell can be identified later.
+func (h *history) prepare(app *config.AppProperties) error {
+	if err := os.MkdirAll(filepath.Dir(h.path), os.ModePerm&app.PermConfigDir); err != nil {
+		return errors.Trace(err)
+	}
+	fh, err := os.OpenFile(h.path, os.O_RDONLY|os.O_CREATE, os.ModePerm&app.PermConfigFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer fh.Close()
+	h.perm = os.ModePerm & app.PermConfigFile
+	if err := fh.Chmod(h.perm); err != nil {
+		return errors.Trace(err)
+	}
+	if h.conf.Merge {
+		if h.before, err = ioutil.ReadAll(fh); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// rc returns the shell code that configures the shell to use the receiver's
+// history file and size limits.
+func (h *history) rc() []byte {
+	var rc bytes.Buffer
+	line := func(key string, val string) {
+		fmt.Fprintln(&rc, h.dialect.Set(key, val))
+	}
+	size := func(n int) string { return strconv.Itoa(n) }
+	fmt.Fprintln(&rc)
+	switch h.dialect {
+	case DialectFish:
+		// fish does not support limiting the size of its history
+		line("fish_history", h.name)
+	case DialectZsh:
+		line("HISTFILE", h.path)
+		if h.conf.Size > 0 {
+			line("HISTSIZE", size(h.conf.Size))
+		}
+		if h.conf.FileSize > 0 {
+			line("SAVEHIST", size(h.conf.FileSize))
+		}
+	default:
+		line("HISTFILE", h.path)
+		if h.conf.Size > 0 {
+			line("HISTSIZE", size(h.conf.Size))
+		}
+		if h.conf.FileSize > 0 {
+			line("HISTFILESIZE", size(h.conf.FileSize))
+		}
+	}
+	return rc.Bytes()
+}
+
+// merge appends each command added to the history file since prepare to the
+// global history file.
+func (h *history) merge() error {
+	after, err := ioutil.ReadFile(h.path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var added []byte
+	if bytes.HasPrefix(after, h.before) {
+		added = after[len(h.before):]
+	} else {
+		// the shell truncated its history file, so find where the original content
+		// ended using the final (complete) lines of the original content.
+		tail := h.before
+		if len(tail) > mergeContext {
+			tail = tail[len(tail)-mergeContext:]
+			if i := bytes.IndexByte(tail, '\n'); i >= 0 {
+				tail = tail[i+1:]
+			}
+		}
+		i := bytes.LastIndex(after, tail)
+		if len(tail) == 0 || i < 0 {
+			return errors.Errorf("cannot locate new commands in %s", h.path)
+		}
+		added = after[i+len(tail):]
+	}
+	if len(strings.TrimSpace(string(added))) == 0 {
+		return nil
+	}
+	fh, err := os.OpenFile(h.global, os.O_WRONLY|os.O_CREATE|os.O_APPEND, h.perm)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer fh.Close()
+	_, err = fh.Write(added)
+	return errors.Trace(err)
+}
@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package shell
+
+import (
+	"io"
+
+	"github.com/juju/errors"
+)
+
+// Run starts the receiver's command and waits for it to exit.
+func (sh *Shell) Run() error {
+	return sh.Cmd.Run()
+}
+
+// RunPTY starts the receiver's command with a pseudo-terminal owned by gosh,
+// which is not supported on this platform.
+func (sh *Shell) RunPTY(tee ...io.Writer) error {
+	return errors.NotSupportedf("pseudo-terminal")
+}
@@ -0,0 +1,297 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package shell
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/juju/errors"
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// relaySignals are forwarded to the shell whenever gosh receives them.
+var relaySignals = []os.Signal{
+	syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT,
+	syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGWINCH,
+}
+
+// resizer is implemented by the writers given to RunPTY that must be notified
+// of changes to the size of the terminal.
+type resizer interface {
+	Resize(cols, rows int) error
+}
+
+// Run starts the receiver's command and waits for it to exit, forwarding each
+// signal received by gosh to the command.
+//
+// If gosh is in the foreground of its controlling terminal, the command is
+// started in its own process group and given the foreground. If the command is
+// then stopped (e.g., via ^Z), gosh stops itself so that the parent shell may
+// regain the terminal, and gosh resumes the command in the foreground when
+// gosh is continued.
+func (sh *Shell) Run() error {
+
+	cmd := sh.Cmd
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	tty, isJob := foregroundTerminal()
+	if isJob {
+		cmd.SysProcAttr.Setpgid = true
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = tty
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, append(relaySignals, syscall.SIGCONT)...)
+	defer signal.Stop(sig)
+
+	if err := cmd.Start(); err != nil {
+		return errors.Trace(err)
+	}
+	pid := cmd.Process.Pid
+	if isJob {
+		defer setForeground(tty, syscall.Getpgrp())
+	}
+
+	return supervise(cmd, sig, func(s os.Signal) {
+		switch s {
+		case syscall.SIGINT, syscall.SIGQUIT:
+			// without its own process group, the command already received any
+			// signal generated by the terminal.
+			if isJob {
+				cmd.Process.Signal(s)
+			}
+		default:
+			cmd.Process.Signal(s)
+		}
+	}, func() {
+		if isJob {
+			setForeground(tty, syscall.Getpgrp())
+		}
+	}, func() {
+		if isJob {
+			setForeground(tty, pid)
+		}
+	})
+}
+
+// RunPTY starts the receiver's command in a new session whose controlling
+// terminal is a pseudo-terminal owned by gosh, and waits for it to exit. All
+// input to gosh is copied to the pseudo-terminal, and all of its output is
+// copied to the command's original stdout along with each of the given
+// writers. Signals received by gosh are forwarded to the command, and changes
+// to the size of gosh's terminal are applied to the pseudo-terminal and
+// reported to each writer that implements resizer.
+func (sh *Shell) RunPTY(tee ...io.Writer) error {
+
+	cmd := sh.Cmd
+	out := cmd.Stdout
+	if out == nil {
+		out = os.Stdout
+	}
+
+	ptm, pts, err := pty.Open()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer ptm.Close()
+
+	isTerm := term.IsTerminal(int(os.Stdin.Fd()))
+	if isTerm {
+		pty.InheritSize(os.Stdin, ptm)
+	}
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = pts, pts, pts
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0 // stdin in the child process
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, append(relaySignals, syscall.SIGCONT)...)
+	defer signal.Stop(sig)
+
+	err = cmd.Start()
+	pts.Close() // only the command's copy remains open
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var state *term.State
+	if isTerm {
+		if state, err = term.MakeRaw(int(os.Stdin.Fd())); err == nil {
+			defer term.Restore(int(os.Stdin.Fd()), state)
+		}
+	}
+
+	// copy input via a non-blocking duplicate of stdin, so that the copy can be
+	// interrupted once the command exits without consuming any further input.
+	in, nonblock, restore := pollableStdin()
+	defer restore()
+	go io.Copy(ptm, in)
+
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(io.MultiWriter(append([]io.Writer{out}, tee...)...), ptm)
+		close(copied)
+	}()
+
+	err = supervise(cmd, sig, func(s os.Signal) {
+		if s == syscall.SIGWINCH {
+			if isTerm {
+				pty.InheritSize(os.Stdin, ptm)
+				if rows, cols, err := pty.Getsize(ptm); err == nil {
+					for _, w := range tee {
+						if r, ok := w.(resizer); ok {
+							r.Resize(cols, rows)
+						}
+					}
+				}
+			}
+			return
+		}
+		cmd.Process.Signal(s)
+	}, func() {
+		nonblock(false)
+		if state != nil {
+			term.Restore(int(os.Stdin.Fd()), state)
+		}
+	}, func() {
+		nonblock(true)
+		if state != nil {
+			term.MakeRaw(int(os.Stdin.Fd()))
+		}
+	})
+
+	// drain any output remaining after the command exited
+	select {
+	case <-copied:
+	case <-time.After(100 * time.Millisecond):
+	}
+	return err
+}
+
+// supervise waits for the started command to exit, calling relay with each
+// signal received on sig (other than SIGCONT).
+//
+// If the command is stopped, gosh calls stop and then stops itself, so that the
+// parent shell may regain the terminal. When gosh is continued, it calls cont
+// and then continues the command's process group.
+func supervise(cmd *exec.Cmd, sig <-chan os.Signal, relay func(os.Signal), stop, cont func()) error {
+
+	pid := cmd.Process.Pid
+	defer cmd.Process.Release()
+
+	type wait struct {
+		ws  syscall.WaitStatus
+		err error
+	}
+	done := make(chan wait)
+	waitNext := func() {
+		var w wait
+		for {
+			_, w.err = syscall.Wait4(pid, &w.ws, syscall.WUNTRACED, nil)
+			if w.err != syscall.EINTR {
+				break
+			}
+		}
+		done <- w
+	}
+	go waitNext()
+
+	stopped := false
+	for {
+		select {
+		case s := <-sig:
+			if s != syscall.SIGCONT {
+				relay(s)
+			} else if stopped {
+				stopped = false
+				cont()
+				syscall.Kill(-pid, syscall.SIGCONT)
+			}
+		case w := <-done:
+			if w.err != nil {
+				return errors.Trace(w.err)
+			}
+			if w.ws.Stopped() {
+				stopped = true
+				stop()
+				syscall.Kill(syscall.Getpid(), syscall.SIGSTOP)
+				go waitNext()
+				continue
+			}
+			return exitError(w.ws)
+		}
+	}
+}
+
+// foregroundTerminal returns the file descriptor of gosh's controlling terminal
+// and whether or not gosh is its foreground process group.
+func foregroundTerminal() (int, bool) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return -1, false
+	}
+	pgrp, err := unix.IoctlGetInt(fd, unix.TIOCGPGRP)
+	if err != nil || pgrp != syscall.Getpgrp() {
+		return -1, false
+	}
+	return fd, true
+}
+
+// setForeground makes process group pgrp the foreground of terminal tty. We may
+// be a background process group, so SIGTTOU must be ignored while doing so.
+func setForeground(tty, pgrp int) {
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	unix.IoctlSetPointerInt(tty, unix.TIOCSPGRP, pgrp)
+}
+
+// pollableStdin returns a non-blocking duplicate of stdin, along with a func
+// that sets or clears its non-blocking mode, and a func that interrupts any
+// pending read and restores stdin to blocking mode.
+//
+// The mode is shared with every other process reading the terminal, so it must
+// be cleared whenever another process (i.e., the parent shell) may read it.
+func pollableStdin() (*os.File, func(bool), func()) {
+	fd, err := syscall.Dup(int(os.Stdin.Fd()))
+	if err != nil {
+		return os.Stdin, func(bool) {}, func() {}
+	}
+	syscall.SetNonblock(fd, true)
+	in := os.NewFile(uintptr(fd), os.Stdin.Name())
+	nonblock := func(nb bool) {
+		syscall.SetNonblock(fd, nb)
+	}
+	restore := func() {
+		in.SetReadDeadline(time.Now())
+		syscall.SetNonblock(fd, false)
+		in.Close()
+	}
+	return in, nonblock, restore
+}
+
+// exitError returns the error reported by a process that exited with the given
+// wait status, or nil if it exited successfully.
+func exitError(ws syscall.WaitStatus) error {
+	switch {
+	case ws.Signaled():
+		return &ExitError{Status: 128 + int(ws.Signal())}
+	case ws.ExitStatus() != 0:
+		return &ExitError{Status: ws.ExitStatus()}
+	}
+	return nil
+}
@@ -2,16 +2,24 @@ package shell
 
 import (
 	"bufio"
+	stderrors "errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/ardnew/gosh/cmd/gosh/config"
+	"github.com/ardnew/gosh/cmd/gosh/environ"
 	"github.com/ardnew/gosh/cmd/gosh/log"
+	"github.com/ardnew/gosh/cmd/gosh/proc"
+	"github.com/ardnew/gosh/cmd/gosh/sandbox"
+	"github.com/ardnew/gosh/cmd/gosh/session"
 	"github.com/juju/errors"
 )
 
@@ -20,134 +28,428 @@ type Shell struct {
 	Cmd *exec.Cmd
 }
 
-// ProfileEnv contains each of the loadable environments available.
-type ProfileEnv map[string][]byte
-
-// Run executes a new shell with the given parameters and does not return until
-// the shell exits or an error was encountered.
-func Run(p *config.Parameters, l *log.Handler, c *config.Config, s *config.Shell, e *ProfileEnv) (shellErr error, cmdErr error) {
+// ProfileSource writes the goshrc content of the named profile to w, and is
+// only called for the profiles being loaded, in the order they are loaded.
+type ProfileSource func(w io.Writer, profile string) error
+
+// Session represents a shell process that has been fully prepared to run, with
+// its goshrc file generated, along with all of its attributes resolved.
+type Session struct {
+	Param    *config.Parameters
+	Log      *log.Handler
+	Config   *config.Config
+	Shell    *config.Shell
+	RCFile   string
+	Profiles []string
+	Env      []string
+	Args     []string
+	Dir      string
+	Attr     *proc.Attr
+	Box      *sandbox.Spec
+	// Wait indicates the shell must always be started as a child process, even
+	// when running a command (which otherwise replaces the current process).
+	Wait bool
+	// Record is the path to the asciinema recording of the session, if any.
+	Record string
+	// DirProfile is the profile whose cwd defined Dir, if any.
+	DirProfile string
+	// ID identifies the session, and Stack contains each of the sessions
+	// enclosing it (including itself, last).
+	ID     string
+	Stack  session.Stack
+	vars   []string
+	hist   *history
+	base   *baseEnviron
+	cached bool
+}
 
-	goshrc, profiles, err := writeEnvToFile(p, l, c, e)
+// Prepare generates the goshrc file and resolves all attributes of a new shell
+// with the given parameters, without starting it. The caller must call Close
+// once the session is no longer needed to remove the goshrc file.
+//
+// If k is non-nil, the goshrc file is reused from (or else generated into) the
+// cache k, and is not removed by Close.
+//
+// If p.Explain is set, no other file is created (e.g., the isolated history),
+// since the shell is never started.
+//
+// The variables in v (formatted as os.Environ) are added to the environment of
+// the new shell, overriding any inherited variables of the same name.
+func Prepare(p *config.Parameters, l *log.Handler, c *config.Config, s *config.Shell, e ProfileSource, k *RCCache, v []string) (*Session, error) {
+
+	ss := &Session{Param: p, Log: l, Config: c, Shell: s, vars: v}
+
+	base := p.Environ()
+	env := environ.Merge(base, v...)
+
+	procs := []*config.Process{}
+	boxes := []*config.Sandbox{}
+	for _, name := range p.ProfileOrder() {
+		if pro, ok := c.Profile[name]; ok {
+			procs = append(procs, pro.Process)
+			boxes = append(boxes, pro.Sandbox)
+		}
+	}
+	attr, err := proc.New(procs...)
 	if err != nil {
-		return errors.Trace(err), nil
+		return nil, errors.Trace(err)
+	}
+	env = environ.Merge(env, attr.Environ()...)
+	ss.Attr = attr
+	ss.Box = sandbox.New(p.App.HomeDir(), boxes...)
+	ss.Record = recordPath(p, c)
+
+	// configure the isolated history file last, overriding any includes
+	var tail []byte
+	ss.hist = newHistory(p, c, DialectOf(s), env)
+	if ss.hist != nil && p.Explain == "" {
+		if err := ss.hist.prepare(&p.App); err != nil {
+			l.Context().WithError(errors.Trace(err)).Warn("shared history")
+			ss.hist = nil
+		}
+	}
+	if ss.hist != nil {
+		tail = ss.hist.rc()
+		l.Context().
+			WithField("path", ss.hist.path).
+			WithField("merge", ss.hist.conf.Merge).
+			Debug("isolated history")
 	}
-	defer os.Remove(goshrc)
 
-	var env []string
-	if !p.OrphanEnviron {
-		env = os.Environ()
+	if p.ShellCommand == "" && !p.GenerateGoshrc && p.Explain == "" && sourcesGoshrc(p, s) {
+		ss.base, err = newBaseEnviron(filepath.Join(p.App.StateDir(), "env"), p.App.PermConfigDir, base, l.Redactor())
+		if err != nil {
+			l.Context().WithError(errors.Trace(err)).Warn("base environment not recorded")
+		} else {
+			tail = append(tail, envReportRC(DialectOf(s))...)
+		}
+	}
+
+	profiles := loadedProfiles(p, l, c)
+	goshrc, err := ss.cachedGoshrc(k, profiles, e, tail)
+	if err != nil {
+		return nil, errors.Trace(err)
 	}
+	if goshrc == "" {
+		if goshrc, err = writeEnvToFile(os.TempDir(), p, l, c, profiles, e, tail); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	ss.RCFile, ss.Profiles = goshrc, profiles
 
 	const goshKey = "GOSH_RCFILE"
-	goshVal := goshrc
-
-	const profKey = "GOSH_PROFILE"
-	profVal := strings.Join(profiles, ",")
-
-	envHasProf, envHasGosh := false, false
-	for i, e := range env {
-		v := strings.SplitN(e, "=", 2)
-		if len(v) > 0 {
-			switch v[0] {
-			case goshKey:
-				// redefine the goshrc file if it already exists in the env
-				env[i] = fmt.Sprintf("%s=%s", goshKey, goshVal)
-				envHasGosh = true
-			case profKey:
-				if len(v) > 1 {
-					// keep the profiles that were already set previously
-					profVal = fmt.Sprintf("%s(%s)", profVal, v[1])
+	// redefine the goshrc file if it already exists in the env, but do not add
+	// it if we are only generating it (it will be deleted)
+	if _, ok := environ.Lookup(env, goshKey); ok || !p.GenerateGoshrc {
+		env = environ.Merge(env, fmt.Sprintf("%s=%s", goshKey, goshrc))
+	}
+
+	// add this session to the stack of sessions enclosing the shell
+	stack, err := session.ParseStack(func(key string) (string, bool) {
+		return environ.Lookup(env, key)
+	})
+	if err != nil {
+		l.Context().WithError(err).Warn("enclosing sessions unknown")
+	}
+	ss.ID = session.NewID()
+	ss.Stack = stack.Push(session.Frame{
+		ID:       ss.ID,
+		Shell:    p.Shell,
+		Profiles: profiles,
+		Start:    time.Now(),
+	})
+	env = environ.Merge(env, ss.Stack.Environ()...)
+
+	// record how the profiles change the environment inherited before loading
+	// any of them, so that the changes can be reversed later (e.g., to unload
+	// them from an interactive shell).
+	if ss.base != nil {
+		env = environ.Merge(env,
+			fmt.Sprintf("%s=%s", BaseEnvironKey, ss.base.file.Name()),
+			fmt.Sprintf("%s=1", envReportKey))
+	}
+	ss.Env = env
+
+	wd, wdErr := os.Getwd()
+	if nil != wdErr {
+		wd = p.App.HomeDir()
+	}
+
+	var arg []string
+	exp := config.NewArgExpansion(p.App.PackageName, s.Exec, goshrc, wd, p.ShellCommand, p.ShellArgs...)
+	if p.ShellCommand == "" {
+		if p.LoginShell {
+			arg = nonEmpty(exp.ExpandArgs(append([]string{s.Exec}, s.Flag.LoginShell...)...)...)
+		} else if p.Interactive {
+			arg = nonEmpty(exp.ExpandArgs(append([]string{s.Exec}, s.Flag.Interactive...)...)...)
+		} else {
+			arg = nonEmpty(exp.ExpandArgs(s.Exec)...)
+		}
+	} else {
+		arg = nonEmpty(exp.ExpandArgs(append([]string{s.Exec}, s.Flag.CommandLine...)...)...)
+	}
+
+	// Use the first non-empty CWD defined among each given profile
+	done := false
+	for _, pro := range p.Profiles {
+		if pd, ok := c.Profile[pro]; ok {
+			switch cwd := exp.Expand(pd.Cwd); s := cwd.(type) {
+			case string:
+				wd, done = s, true
+				if s != "" {
+					ss.DirProfile = pro
 				}
-				env[i] = fmt.Sprintf("%s=%s", profKey, profVal)
-				envHasProf = true
 			}
 		}
+		if done {
+			// Outside of switch-block, because I'm not sure if "break" would jump
+			// out of case-block or for-loop body. Trivial to make certain.
+			break
+		}
 	}
+	ss.Args, ss.Dir = arg, wd
+
+	return ss, nil
+}
 
-	// do not append goshrc file if we are only generating it (it will be deleted)
-	if !envHasGosh && !p.GenerateGoshrc {
-		env = append(env, fmt.Sprintf("%s=%s", goshKey, goshVal))
+// cachedGoshrc returns the path to the goshrc file for the given profiles in
+// cache k, generating it first if not already cached. The returned path is empty
+// if k is nil or the cache cannot be used.
+func (ss *Session) cachedGoshrc(k *RCCache, profiles []string, e ProfileSource, tail []byte) (string, error) {
+	if k == nil {
+		return "", nil
+	}
+	p, l, c := ss.Param, ss.Log, ss.Config
+	if err := os.MkdirAll(k.Dir, os.ModePerm&k.PermDir); err != nil {
+		l.Context().WithError(errors.Trace(err)).Warn("goshrc not cached")
+		return "", nil
+	}
+	path := k.path(ss.Shell, tail)
+	if k.lookup(path) {
+		l.Context().
+			WithField("profiles", profiles).
+			WithField("path", fmt.Sprintf("⮔ %s", path)).
+			Info("reusing cached goshrc")
+		ss.cached = true
+		return path, nil
+	}
+	// generate the goshrc in the cache directory, so that it can be moved into
+	// place atomically once complete.
+	tmp, err := writeEnvToFile(k.Dir, p, l, c, profiles, e, tail)
+	if err != nil {
+		return "", errors.Trace(err)
 	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		l.Context().WithError(errors.Trace(err)).Warn("goshrc not cached")
+		return "", nil
+	}
+	l.Context().WithField("path", path).Debug("cached goshrc")
+	ss.cached = true
+	k.collect(l, p.App.PackageName+"rc-")
+	return path, nil
+}
+
+// Cached returns true if the goshrc file of the receiver Session is cached for
+// reuse by later sessions, and therefore remains after the session is closed.
+func (ss *Session) Cached() bool {
+	return ss.cached
+}
 
-	if !envHasProf {
-		env = append(env, fmt.Sprintf("%s=%s", profKey, profVal))
+// Close removes the goshrc file generated for the receiver Session, unless it
+// is cached for reuse by later sessions.
+func (ss *Session) Close() error {
+	if ss.base != nil {
+		ss.base.close()
 	}
+	if ss.cached {
+		return nil
+	}
+	return errors.Trace(os.Remove(ss.RCFile))
+}
 
-	if p.GenerateGoshrc {
+// Generate writes the generated goshrc file, including the exported environment,
+// to the given writer instead of starting a new shell.
+func (ss *Session) Generate(out io.Writer) error {
+	return errors.Trace(copyGoshrc(out, ss.Env, ss.vars, ss.Log.Redactor(), ss.RCFile, ss.Param, ss.Config, ss.Shell))
+}
 
-		return nil, errors.Trace(copyGoshrc(os.Stdout, env, goshrc, p, c))
+// Launch methods returned by Session.Launch.
+const (
+	LaunchSandbox = "sandbox" // a child process in a new sandbox
+	LaunchChild   = "child"   // a child process that gosh waits on
+	LaunchExec    = "exec"    // replacing the gosh process
+)
 
-	} else {
+// Launch returns how Run starts the shell. The shell replaces the gosh process
+// only when running a command with nothing left to do once it exits (including
+// removing an uncached goshrc file).
+func (ss *Session) Launch() string {
+	return ss.LaunchCached(ss.cached)
+}
 
-		wd, wdErr := os.Getwd()
-		if nil != wdErr {
-			wd = p.App.HomeDir()
+// LaunchCached is the same as Launch, but as if the goshrc file was (or was not)
+// cached, regardless of whether it is.
+func (ss *Session) LaunchCached(cached bool) string {
+	p := ss.Param
+	switch {
+	case ss.Box != nil:
+		return LaunchSandbox
+	case p.ShellCommand == "" || ss.Wait || !cached || p.PseudoTerminal || ss.Record != "":
+		return LaunchChild
+	}
+	return LaunchExec
+}
+
+// Run executes the shell and does not return until the shell exits or an error
+// was encountered. If a command is being run and Wait is false, the current
+// process is replaced by the shell, and Run does not return unless an error was
+// encountered.
+func (ss *Session) Run() error {
+
+	p, l, s := ss.Param, ss.Log, ss.Shell
+	arg, env, wd := ss.Args, ss.Env, ss.Dir
+
+	l.Context().
+		WithField("shell", s.Exec).
+		WithField("args", fmt.Sprintf("[%s]", strings.Join(arg, ", "))).
+		WithField("env", env).
+		WithField("dir", wd).
+		WithField("process", ss.Attr.String()).
+		WithField("sandbox", ss.Box.String()).
+		WithField("record", ss.Record).
+		WithField("stdin", os.Stdin.Name()).
+		WithField("stdout", os.Stdout.Name()).
+		WithField("stderr", os.Stderr.Name()).
+		Debug("execute")
+
+	// merge the isolated history (if any) back into the global history after
+	// the shell exits.
+	mergeHistory := func() {
+		if ss.hist != nil && ss.hist.conf.Merge {
+			if err := ss.hist.merge(); err != nil {
+				l.Context().WithError(errors.Trace(err)).Warn("history not merged")
+			}
 		}
+	}
 
-		var arg []string
-		exp := config.NewArgExpansion(p.App.PackageName, s.Exec, goshrc, wd, p.ShellCommand, p.ShellArgs...)
-		if p.ShellCommand == "" {
-			if p.LoginShell {
-				arg = nonEmpty(exp.ExpandArgs(append([]string{s.Exec}, s.Flag.LoginShell...)...)...)
-			} else if p.Interactive {
-				arg = nonEmpty(exp.ExpandArgs(append([]string{s.Exec}, s.Flag.Interactive...)...)...)
-			} else {
-				arg = nonEmpty(exp.ExpandArgs(s.Exec)...)
+	// wait on the shell in a child process, forwarding signals to it, unless
+	// we are running a command and have nothing to do once it exits (including
+	// removing an uncached goshrc file).
+	child := func(cmd *exec.Cmd) error {
+		if ss.base != nil {
+			w := ss.reportEnviron()
+			if w != nil {
+				defer w.Close()
+				extra := make([]*os.File, envReportFD-2)
+				extra[envReportFD-3] = w
+				cmd.ExtraFiles = extra
 			}
+		}
+		shell := &Shell{Cmd: cmd}
+		var err error
+		if ss.Record != "" {
+			err = ss.record(shell)
+		} else if p.PseudoTerminal {
+			err = shell.RunPTY()
 		} else {
-			arg = nonEmpty(exp.ExpandArgs(append([]string{s.Exec}, s.Flag.CommandLine...)...)...)
+			err = shell.Run()
 		}
+		mergeHistory()
+		return err
+	}
 
-		// Use the first non-empty CWD defined among each given profile
-		done := false
-		for _, pro := range p.Profiles {
-			if pd, ok := c.Profile[pro]; ok {
-				switch cwd := exp.Expand(pd.Cwd); s := cwd.(type) {
-				case string:
-					wd, done = s, true
-				}
-			}
-			if done {
-				// Outside of switch-block, because I'm not sure if "break" would jump
-				// out of case-block or for-loop body. Trivial to make certain.
-				break
+	var run func() error
+	switch ss.Launch() {
+	case LaunchSandbox:
+		// the sandbox requires a new process, even when running a command
+		run = func() error {
+			// the process attributes are applied once the sandbox is initialized.
+			box := *ss.Box
+			box.Attr = ss.Attr
+			cmd, err := box.Command(s.Exec, arg, env, wd, ss.RCFile)
+			if err != nil {
+				return err
 			}
+			return child(cmd)
 		}
-
-		l.Context().
-			WithField("shell", s.Exec).
-			WithField("args", fmt.Sprintf("[%s]", strings.Join(arg, ", "))).
-			WithField("env", fmt.Sprintf("[%s]", strings.Join(env, ", "))).
-			WithField("dir", wd).
-			WithField("stdin", os.Stdin.Name()).
-			WithField("stdout", os.Stdout.Name()).
-			WithField("stderr", os.Stderr.Name()).
-			Debug("execute")
-
-		var run func() error
-		if p.ShellCommand == "" {
-			run = func() error {
-				shell := &Shell{Cmd: &exec.Cmd{
-					Path:   s.Exec,
-					Args:   arg,
-					Env:    env,
-					Dir:    wd,
-					Stdin:  os.Stdin,
-					Stdout: os.Stdout,
-					Stderr: os.Stderr,
-				}}
-				return shell.Cmd.Run()
+	case LaunchChild:
+		run = func() error {
+			path, arg, env, err := ss.Attr.Command(s.Exec, arg, env)
+			if err != nil {
+				return errors.Annotate(err, "process attributes")
 			}
-		} else {
-			run = func() error {
-				return syscall.Exec(s.Exec, arg, env)
+			return child(&exec.Cmd{
+				Path:   path,
+				Args:   arg,
+				Env:    env,
+				Dir:    wd,
+				Stdin:  os.Stdin,
+				Stdout: os.Stdout,
+				Stderr: os.Stderr,
+			})
+		}
+	default:
+		run = func() error {
+			// the shell replaces gosh, so the process attributes are applied to
+			// gosh itself. some are per-thread, so the shell must start from this
+			// thread.
+			runtime.LockOSThread()
+			if err := ss.Attr.Apply(); err != nil {
+				return errors.Annotate(err, "process attributes")
 			}
+			return syscall.Exec(s.Exec, arg, env)
+		}
+	}
+	// the process attributes are only applied to the shell (by a new gosh
+	// process that then executes it, see proc.Attr.Command and sandbox.Init),
+	// never to the gosh process that waits on it, unless the shell replaces gosh.
+	return errors.Trace(run())
+}
+
+// reportEnviron returns the file to which the shell reports its environment,
+// which is recorded in the background, or nil if it cannot be created.
+func (ss *Session) reportEnviron() *os.File {
+	r, w, err := os.Pipe()
+	if err != nil {
+		ss.Log.Context().WithError(errors.Trace(err)).Warn("base environment not recorded")
+		return nil
+	}
+	go func() {
+		defer r.Close()
+		if err := ss.base.record(r); err != nil {
+			ss.Log.Context().WithError(errors.Trace(err)).Debug("base environment not recorded")
+		}
+	}()
+	return w
+}
+
+// record runs the shell under a pseudo-terminal, recording all of its output
+// to the session's asciinema recording.
+func (ss *Session) record(shell *Shell) error {
+	head := castHeader{
+		Command: ss.Param.ShellCommand,
+		Title:   ss.Param.App.PackageName + ": " + strings.Join(ss.Profiles, ", "),
+		Env:     map[string]string{"SHELL": ss.Shell.Exec},
+	}
+	for _, key := range []string{"TERM", "GOSH_PROFILE"} {
+		if val, ok := environ.Lookup(ss.Env, key); ok {
+			head.Env[key] = val
 		}
-		return nil, errors.Trace(run())
 	}
+	rec, err := newCast(ss.Record, &ss.Param.App, head)
+	if err != nil {
+		return errors.Annotate(err, "recording not created")
+	}
+	ss.Log.Context().WithField("path", ss.Record).Info("recording session")
+	err = shell.RunPTY(rec)
+	if cerr := rec.Close(); cerr != nil {
+		ss.Log.Context().WithError(cerr).Warn("recording not closed")
+	}
+	return err
 }
 
-func copyGoshrc(out io.Writer, env []string, path string, par *config.Parameters, cfg *config.Config) error {
+func copyGoshrc(out io.Writer, env, vars []string, red *environ.Redactor, path string, par *config.Parameters, cfg *config.Config, sh *config.Shell) error {
 
 	// open the file for reading
 	fh, err := os.Open(path)
@@ -157,20 +459,31 @@ func copyGoshrc(out io.Writer, env []string, path string, par *config.Parameters
 	defer fh.Close()
 
 	// first line is always the interpreter
-	bang := fmt.Sprintf("#!%s", cfg.Shell)
+	bang := fmt.Sprintf("#!%s", sh.Exec)
 	_, err = fmt.Fprintln(out, bang)
 	if nil == err {
-		// export the environment unless orphan specified
-		if !par.OrphanEnviron {
-			for _, s := range env {
-				v := strings.SplitN(s, "=", 2)
-				if len(v) > 1 {
-					s = fmt.Sprintf("%s=%q", v[0], v[1])
-					_, err = fmt.Fprintln(out, "export", s)
-					if nil != err {
-						break
-					}
+		// export the environment unless orphan specified, in which case only the
+		// variables defined in profile envfiles are exported.
+		if par.OrphanEnviron {
+			env = vars
+		}
+		// secret variables are never exported, and other sensitive variables are
+		// only exported if not redacted by the user's configuration.
+		for _, s := range env {
+			if nil != err {
+				break
+			}
+			v := strings.SplitN(s, "=", 2)
+			if len(v) > 1 {
+				if red.IsSecret(v[0]) || (cfg.Redact.Goshrc && red.IsSensitive(v[0])) {
+					_, err = fmt.Fprintf(out, "# export %s=%s\n", v[0], environ.Redacted)
+					continue
 				}
+				if cfg.Redact.Goshrc {
+					v[1] = red.Value(v[1])
+				}
+				s = fmt.Sprintf("%s=%q", v[0], v[1])
+				_, err = fmt.Fprintln(out, "export", s)
 			}
 		}
 		// copy the file contents
@@ -190,60 +503,105 @@ func copyGoshrc(out io.Writer, env []string, path string, par *config.Parameters
 	return errors.Trace(err)
 }
 
-func writeEnvToFile(p *config.Parameters, l *log.Handler, c *config.Config, e *ProfileEnv) (string, []string, error) {
+// sourcesGoshrc returns true if the interactive (or login) shell started with
+// the given parameters is given the goshrc file as an argument, and therefore
+// sources it (unlike, e.g., tmux).
+func sourcesGoshrc(p *config.Parameters, s *config.Shell) bool {
+	const goshrc = "\x00goshrc"
+	flag := s.Flag.Interactive
+	if p.LoginShell {
+		flag = s.Flag.LoginShell
+	} else if !p.Interactive {
+		return false
+	}
+	exp := config.NewArgExpansion(p.App.PackageName, s.Exec, goshrc, "", "")
+	for _, arg := range exp.ExpandArgs(flag...) {
+		if arg == goshrc {
+			return true
+		}
+	}
+	return false
+}
+
+// loadedProfiles returns the names of the profiles to load that are defined in
+// the configuration, in the order they are loaded.
+func loadedProfiles(p *config.Parameters, l *log.Handler, c *config.Config) []string {
+	sel := []string{}
+	for _, name := range p.ProfileOrder() {
+		if _, ok := c.Profile[name]; !ok {
+			l.Context().
+				WithField("profile", name).
+				Warn("undefined profile")
+			continue
+		}
+		sel = append(sel, name)
+	}
+	return sel
+}
+
+func writeEnvToFile(dir string, p *config.Parameters, l *log.Handler, c *config.Config, sel []string, e ProfileSource, tail []byte) (string, error) {
 
 	var env *os.File
 	var err error
 
-	env, err = tempFile(p.App.PackageName + "rc-")
+	env, err = tempFile(dir, p.App.PackageName+"rc-")
 	if err != nil {
-		return "", nil, errors.Trace(err)
+		return "", errors.Trace(err)
 	}
-
-	var cnt int
-	var pos int64
-
-	seen := map[string]int{}
-	for i, sel := range append([]string{p.App.ReqProfileName}, p.Profiles...) {
-
-		if _, ok := seen[sel]; ok {
-			continue
+	defer func() {
+		// do not leave behind an incomplete goshrc file
+		if err != nil {
+			env.Close()
+			os.Remove(env.Name())
+		}
+	}()
+
+	// each profile is streamed directly into the goshrc file
+	buf := bufio.NewWriter(env)
+	for _, name := range sel {
+		cnt := &countWriter{w: buf}
+		if err = e(cnt, name); err != nil {
+			return "", errors.Trace(err)
 		}
 
-		seen[sel] = i
-		if bytes, found := (*e)[sel]; found {
-			pos += int64(cnt)
-			cnt, err = env.WriteAt(bytes, pos)
-			if err != nil {
-				return "", nil, errors.Trace(err)
-			}
+		l.Context().
+			WithField("profile", name).
+			WithField("env", c.Profile[name].Env).
+			WithField("size", fmt.Sprintf("%dB", cnt.n)).
+			WithField("path", fmt.Sprintf("⮔ %s", env.Name())).
+			Info("activated profile")
+	}
 
-			l.Context().
-				WithField("profile", sel).
-				WithField("env", fmt.Sprintf("[ %s ]", strings.Join(c.Profile[sel].Env, ", "))).
-				WithField("size", fmt.Sprintf("%dB", cnt)).
-				WithField("path", fmt.Sprintf("⮔ %s", env.Name())).
-				Info("activated profile")
+	if len(tail) > 0 {
+		if _, err = buf.Write(tail); err != nil {
+			return "", errors.Trace(err)
 		}
 	}
 
-	sel := make([]string, len(seen))
-	i := 0
-	for s := range seen {
-		sel[i] = s
-		i++
+	if err = buf.Flush(); err != nil {
+		return "", errors.Trace(err)
 	}
-
 	if err = env.Close(); err != nil {
-		return "", nil, errors.Trace(err)
+		return "", errors.Trace(err)
 	}
 
-	return env.Name(), sel, nil
+	return env.Name(), nil
 }
 
-func tempFile(prefix string) (*os.File, error) {
-	tmpDir := os.TempDir()
-	src, err := ioutil.TempFile(tmpDir, prefix)
+// countWriter counts the number of bytes written to the underlying writer.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+func tempFile(dir, prefix string) (*os.File, error) {
+	src, err := ioutil.TempFile(dir, prefix)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -271,3 +629,35 @@ func nonEmpty(ls ...string) []string {
 	}
 	return rs
 }
+
+// ExitError is returned by Shell.Run when the shell exits with non-zero status
+// (including 128 plus the signal number if the shell was killed by a signal).
+type ExitError struct {
+	Status int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Status)
+}
+
+// ExitStatus returns the exit status of a shell whose Run returned err, and
+// whether or not the shell actually ran and exited (as opposed to err being a
+// failure to start the shell). If the shell was killed by a signal, the exit
+// status is 128 plus the signal number, as reported by most shells.
+func ExitStatus(err error) (int, bool) {
+	if err == nil {
+		return 0, true
+	}
+	var status *ExitError
+	if stderrors.As(errors.Cause(err), &status) {
+		return status.Status, true
+	}
+	var exit *exec.ExitError
+	if stderrors.As(errors.Cause(err), &exit) {
+		if ws, ok := exit.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal()), true
+		}
+		return exit.ExitCode(), true
+	}
+	return -1, false
+}
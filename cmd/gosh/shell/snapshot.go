@@ -0,0 +1,195 @@
+package shell
+
+import (
+	"bufio"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// snapshotFD is the file descriptor to which a snapshot of the shell's state is
+// written.
+const snapshotFD = 3
+
+// snapshotMark begins each line naming a section of the snapshot.
+const snapshotMark = "\036"
+
+// Snapshot is the state of a shell after sourcing its goshrc file: its exported
+// environment, shell functions, and aliases, each keyed by name.
+type Snapshot struct {
+	Env      map[string]string `json:"env"`
+	Function map[string]string `json:"function"`
+	Alias    map[string]string `json:"alias"`
+}
+
+// SnapshotCommand returns the command run by a shell of the given dialect to
+// write its state to the snapshot file descriptor. Shell functions cannot be
+// listed in the POSIX dialect, so they are never included in its snapshot.
+func SnapshotCommand(d Dialect) string {
+	const (
+		env   = `printf '\036env\n'; env; printf '\036alias\n'; alias; `
+		entry = `printf '\036function %s\n' "$f"; `
+	)
+	fd := fmt.Sprintf(">&%d", snapshotFD)
+	switch d {
+	case DialectBash:
+		return `{ ` + env + `for f in $(compgen -A function); do ` + entry + `declare -f "$f"; done; } ` + fd
+	case DialectZsh:
+		return `{ ` + env + `for f in ${(k)functions}; do ` + entry + `functions "$f"; done; } ` + fd
+	case DialectFish:
+		return `begin; ` + env + `for f in (functions -n); ` + strings.ReplaceAll(entry, `"$f"`, `$f`) + `functions $f; end; end ` + fd
+	default:
+		return `{ ` + env + `} ` + fd
+	}
+}
+
+// Snapshot runs the shell non-interactively and returns its state once its
+// goshrc file has been sourced. The session must have been prepared with the
+// command returned by SnapshotCommand. Any output from the shell is discarded.
+func (ss *Session) Snapshot() (*Snapshot, error) {
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer r.Close()
+
+	null, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer null.Close()
+
+	extra := make([]*os.File, snapshotFD-2)
+	extra[snapshotFD-3] = w
+	cmd := &exec.Cmd{
+		Path:       ss.Shell.Exec,
+		Args:       ss.Args,
+		Env:        ss.Env,
+		Dir:        ss.Dir,
+		Stdin:      null,
+		Stdout:     null,
+		Stderr:     null,
+		ExtraFiles: extra,
+	}
+
+	var snap *Snapshot
+	var readErr error
+	read := make(chan struct{})
+	go func() {
+		snap, readErr = parseSnapshot(r)
+		close(read)
+	}()
+
+	err = cmd.Start()
+	w.Close() // only the shell's copy remains open
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	runErr := cmd.Wait()
+	if _, exited := ExitStatus(runErr); !exited {
+		return nil, errors.Trace(runErr)
+	}
+	// background processes started by the shell may have inherited the pipe, so
+	// do not wait for it to be closed once the shell has exited.
+	r.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	<-read
+	if readErr != nil && !stderrors.Is(errors.Cause(readErr), os.ErrDeadlineExceeded) {
+		return nil, errors.Trace(readErr)
+	}
+	if snap.Env == nil {
+		return nil, errors.Errorf("no snapshot was written (%v)", runErr)
+	}
+	return snap, nil
+}
+
+var (
+	// snapshotVar matches the first line of each variable printed by env(1);
+	// any other line continues the value of the previous variable.
+	snapshotVar = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)=`)
+	// snapshotFunc matches the first line of each function exported by bash,
+	// which is not a variable of interest.
+	snapshotFunc = regexp.MustCompile(`^BASH_FUNC_[^=]*=`)
+)
+
+func parseSnapshot(r io.Reader) (*Snapshot, error) {
+	snap := &Snapshot{}
+	scan := bufio.NewScanner(r)
+	scan.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var section, name string
+	var value []string
+	flush := func() {
+		if name != "" {
+			val := strings.Join(value, "\n")
+			switch section {
+			case "env":
+				snap.Env[name] = val
+			case "alias":
+				snap.Alias[name] = val
+			case "function":
+				snap.Function[name] = val
+			}
+		}
+		name, value = "", nil
+	}
+	for scan.Scan() {
+		line := scan.Text()
+		if strings.HasPrefix(line, snapshotMark) {
+			flush()
+			field := strings.SplitN(strings.TrimPrefix(line, snapshotMark), " ", 2)
+			section = field[0]
+			if section == "end" {
+				// the writer may not close its end, so stop reading here
+				break
+			}
+			switch section {
+			case "env":
+				snap.Env = map[string]string{}
+				snap.Function = map[string]string{}
+				snap.Alias = map[string]string{}
+			case "function":
+				if len(field) > 1 {
+					name = field[1]
+				}
+			}
+			continue
+		}
+		switch section {
+		case "env":
+			if m := snapshotVar.FindStringSubmatch(line); m != nil {
+				flush()
+				name, value = m[1], []string{strings.TrimPrefix(line, m[0])}
+			} else if snapshotFunc.MatchString(line) {
+				flush()
+			} else if name != "" {
+				value = append(value, line)
+			}
+		case "alias":
+			flush()
+			name, value = parseAlias(line)
+		case "function":
+			value = append(value, line)
+		}
+	}
+	flush()
+	return snap, errors.Trace(scan.Err())
+}
+
+// parseAlias returns the name and definition of an alias as printed by command
+// alias in each dialect: "alias name='value'" (bash), "name=value" (zsh, sh),
+// or "alias name value" (fish).
+func parseAlias(line string) (string, []string) {
+	line = strings.TrimPrefix(line, "alias ")
+	i := strings.IndexAny(line, "= ")
+	if i <= 0 {
+		return "", nil
+	}
+	return line[:i], []string{line[i+1:]}
+}
@@ -0,0 +1,120 @@
+package shell
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSnapshot(t *testing.T) {
+	// env(1) output shared by each dialect, including a multi-line value and a
+	// function exported by bash.
+	const env = snapshotMark + "env\n" +
+		"HOME=/root\n" +
+		"MULTI=line one\n" +
+		"line two\n" +
+		"BASH_FUNC_greet%%=() {  echo hi\n" +
+		"}\n" +
+		"EMPTY=\n"
+	wantEnv := map[string]string{
+		"HOME":  "/root",
+		"MULTI": "line one\nline two",
+		"EMPTY": "",
+	}
+	for _, tc := range []struct {
+		name      string
+		in        string
+		wantAlias map[string]string
+		wantFunc  map[string]string
+	}{
+		{
+			name: "bash",
+			in: env + snapshotMark + "alias\n" +
+				"alias g='git'\n" +
+				"alias ll='ls -l'\n" +
+				snapshotMark + "function greet\n" +
+				"greet () \n{ \n    echo hi\n}\n",
+			wantAlias: map[string]string{"g": "'git'", "ll": "'ls -l'"},
+			wantFunc:  map[string]string{"greet": "greet () \n{ \n    echo hi\n}"},
+		},
+		{
+			name: "zsh",
+			in: env + snapshotMark + "alias\n" +
+				"g=git\n" +
+				"ll='ls -l'\n" +
+				snapshotMark + "function greet\n" +
+				"greet () {\n\techo hi\n}\n" +
+				snapshotMark + "function bye\n" +
+				"bye () {\n\techo bye\n}\n",
+			wantAlias: map[string]string{"g": "git", "ll": "'ls -l'"},
+			wantFunc: map[string]string{
+				"greet": "greet () {\n\techo hi\n}",
+				"bye":   "bye () {\n\techo bye\n}",
+			},
+		},
+		{
+			name: "fish",
+			in: env + snapshotMark + "alias\n" +
+				"alias g git\n" +
+				"alias ll 'ls -l'\n" +
+				snapshotMark + "function greet\n" +
+				"function greet\n    echo hi\nend\n",
+			wantAlias: map[string]string{"g": "git", "ll": "'ls -l'"},
+			wantFunc:  map[string]string{"greet": "function greet\n    echo hi\nend"},
+		},
+		{
+			name: "posix",
+			in: env + snapshotMark + "alias\n" +
+				"ll='ls -l'\n",
+			wantAlias: map[string]string{"ll": "'ls -l'"},
+			wantFunc:  map[string]string{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			snap, err := parseSnapshot(strings.NewReader(tc.in))
+			if err != nil {
+				t.Fatalf("parseSnapshot() error: %v", err)
+			}
+			if !reflect.DeepEqual(snap.Env, wantEnv) {
+				t.Errorf("Env = %q, want %q", snap.Env, wantEnv)
+			}
+			if !reflect.DeepEqual(snap.Alias, tc.wantAlias) {
+				t.Errorf("Alias = %q, want %q", snap.Alias, tc.wantAlias)
+			}
+			if !reflect.DeepEqual(snap.Function, tc.wantFunc) {
+				t.Errorf("Function = %q, want %q", snap.Function, tc.wantFunc)
+			}
+		})
+	}
+}
+
+func TestParseSnapshotEmpty(t *testing.T) {
+	snap, err := parseSnapshot(strings.NewReader("some output\nfrom the shell\n"))
+	if err != nil {
+		t.Fatalf("parseSnapshot() error: %v", err)
+	}
+	if snap.Env != nil {
+		t.Errorf("Env = %q, want nil without an env section", snap.Env)
+	}
+}
+
+func TestParseAlias(t *testing.T) {
+	for _, tc := range []struct {
+		line, name string
+		value      []string
+	}{
+		{"alias ll='ls -l'", "ll", []string{"'ls -l'"}}, // bash
+		{"ll='ls -l'", "ll", []string{"'ls -l'"}},       // zsh, sh
+		{"g=git", "g", []string{"git"}},                 // zsh without quotes
+		{"alias ll 'ls -l'", "ll", []string{"'ls -l'"}}, // fish
+		{"alias eq='a=b'", "eq", []string{"'a=b'"}},     // value containing "="
+		{"alias sp 'echo a b'", "sp", []string{"'echo a b'"}},
+		{"", "", nil},
+		{"=x", "", nil},
+	} {
+		name, value := parseAlias(tc.line)
+		if name != tc.name || !reflect.DeepEqual(value, tc.value) {
+			t.Errorf("parseAlias(%q) = %q, %q, want %q, %q", tc.line, name, value, tc.name, tc.value)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package shell
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// Prefixes of the comments marking the beginning and end of each block of
+// lines in the goshrc file copied from a profile's include file (or its env).
+// The comment syntax is the same in all supported dialects.
+const (
+	sourceBegin = "# >>> gosh: "
+	sourceEnd   = "# <<< gosh: "
+)
+
+// SourceEnv is the name used in place of an include file for the block of
+// lines copied from a profile's env.
+const SourceEnv = "(env)"
+
+// SourceBlock returns the given lines copied from the named file of a profile,
+// surrounded by marker comments so that each line in the goshrc can be located
+// in its original file (see Locate). A newline is appended to the lines if not
+// already terminated, so that the end marker is always on a line of its own.
+func SourceBlock(profile, file string, lines []byte) []byte {
+	name := sourceName(profile, file)
+	blk := make([]byte, 0, len(lines)+2*(len(sourceBegin)+len(name)+1)+1)
+	blk = append(blk, sourceBegin+name+"\n"...)
+	blk = append(blk, lines...)
+	if len(lines) > 0 && lines[len(lines)-1] != '\n' {
+		blk = append(blk, '\n')
+	}
+	return append(blk, sourceEnd+name+"\n"...)
+}
+
+func sourceName(profile, file string) string {
+	if file == SourceEnv {
+		return profile + " " + SourceEnv
+	}
+	return path.Join(profile, file)
+}
+
+// Location identifies a line in a profile's include file (or env), or in the
+// goshrc itself if Name is empty.
+type Location struct {
+	Name string // profile/file, or "profile (env)"
+	Line int
+}
+
+// String returns the location formatted as "name:line".
+func (loc Location) String() string {
+	if loc.Name == "" {
+		return fmt.Sprintf("goshrc:%d", loc.Line)
+	}
+	return fmt.Sprintf("%s:%d", loc.Name, loc.Line)
+}
+
+// Locate returns the location in its original file of the given line number in
+// a goshrc file read from rc. Lines that were not copied from any profile
+// (e.g., the exported environment) are located in the goshrc itself.
+func Locate(rc io.Reader, line int) (Location, error) {
+	if line < 1 {
+		return Location{}, errors.NotValidf("line number %d", line)
+	}
+	scan := bufio.NewScanner(rc)
+	scan.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	name, begin := "", 0
+	for n := 1; scan.Scan(); n++ {
+		text := scan.Text()
+		if n == line {
+			switch {
+			case strings.HasPrefix(text, sourceBegin), strings.HasPrefix(text, sourceEnd):
+				return Location{Line: line}, nil
+			case name != "":
+				return Location{Name: name, Line: line - begin}, nil
+			default:
+				return Location{Line: line}, nil
+			}
+		}
+		if strings.HasPrefix(text, sourceBegin) {
+			name, begin = strings.TrimPrefix(text, sourceBegin), n
+		} else if strings.HasPrefix(text, sourceEnd) {
+			name, begin = "", 0
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return Location{}, errors.Trace(err)
+	}
+	return Location{}, errors.NotFoundf("line %d", line)
+}
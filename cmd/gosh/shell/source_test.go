@@ -0,0 +1,45 @@
+package shell
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLocate(t *testing.T) {
+	// the line numbers of each part of the goshrc are noted.
+	var rc strings.Builder
+	rc.WriteString("export GOSH_PROFILE=auto\n")                               // 1
+	rc.Write(SourceBlock("auto", SourceEnv, []byte("export A=1\nexport B=2"))) // 2-5
+	rc.Write(SourceBlock("auto", "paths.bash", []byte("PATH=/x:$PATH\n")))     // 6-8
+	rc.Write(SourceBlock("tinygo", "empty.bash", nil))                         // 9-10
+	rc.WriteString("# trailing\n")                                             // 11
+	for _, tc := range []struct {
+		line int
+		want Location
+	}{
+		{1, Location{Line: 1}},
+		{2, Location{Line: 2}}, // begin marker
+		{3, Location{Name: "auto " + SourceEnv, Line: 1}},
+		{4, Location{Name: "auto " + SourceEnv, Line: 2}},
+		{5, Location{Line: 5}}, // end marker
+		{7, Location{Name: "auto/paths.bash", Line: 1}},
+		{8, Location{Line: 8}},
+		{9, Location{Line: 9}},
+		{10, Location{Line: 10}},
+		{11, Location{Line: 11}},
+	} {
+		got, err := Locate(strings.NewReader(rc.String()), tc.line)
+		if err != nil {
+			t.Errorf("Locate(%d) error: %v", tc.line, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("Locate(%d) = %v, want %v", tc.line, got, tc.want)
+		}
+	}
+	for _, line := range []int{-1, 0, 12} {
+		if got, err := Locate(strings.NewReader(rc.String()), line); err == nil {
+			t.Errorf("Locate(%d) = %v, want error", line, got)
+		}
+	}
+}
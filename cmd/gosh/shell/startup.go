@@ -0,0 +1,273 @@
+package shell
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// startupFD is the file descriptor to which each startup marker is written.
+const startupFD = 3
+
+// Startup records the location of each marker inserted into the goshrc file
+// while profiling shell startup. Each marker reports its index to gosh when it
+// is reached by the shell, so that the time spent in each include file can be
+// measured as the time between consecutive markers.
+type Startup struct {
+	mu    sync.Mutex
+	label []startupLabel
+}
+
+type startupLabel struct {
+	profile string
+	file    string // empty if marking the end of a profile
+}
+
+// NewStartup returns a new, empty set of startup markers.
+func NewStartup() *Startup {
+	return &Startup{}
+}
+
+// Marker returns the shell code of a new marker, which is inserted immediately
+// before the given file of the given profile. If file is empty, the marker is
+// inserted at the end of the profile.
+func (st *Startup) Marker(profile, file string) []byte {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.label = append(st.label, startupLabel{profile: profile, file: file})
+	// printf and redirection have the same syntax in all supported dialects
+	return []byte(fmt.Sprintf("\nprintf '%%d\\n' %d >&%d 2>/dev/null\n",
+		len(st.label)-1, startupFD))
+}
+
+// StartupInclude is the time spent sourcing a single include file.
+type StartupInclude struct {
+	Profile  string        `json:"profile"`
+	File     string        `json:"file"`
+	Start    time.Duration `json:"start_us"`
+	Duration time.Duration `json:"duration_us"`
+}
+
+// StartupProfile is the time spent sourcing all include files of a profile.
+type StartupProfile struct {
+	Name     string        `json:"name"`
+	Start    time.Duration `json:"start_us"`
+	Duration time.Duration `json:"duration_us"`
+	Includes int           `json:"includes"`
+}
+
+// StartupReport contains the time spent in each phase of shell startup. All
+// times are relative to the moment the shell process was started.
+type StartupReport struct {
+	Shell    string           `json:"shell"`
+	Total    time.Duration    `json:"total_us"`
+	Init     time.Duration    `json:"init_us"` // before the first include
+	Exit     time.Duration    `json:"exit_us"` // after the last include
+	Status   int              `json:"status"`
+	Includes []StartupInclude `json:"includes"`
+	Profiles []StartupProfile `json:"profiles"`
+}
+
+// ProfileStartup runs the shell non-interactively, with its goshrc containing
+// the markers of st, and returns the time spent sourcing each include file. The
+// session must have been prepared with a command that exits immediately. Any
+// output from the shell is written to stderr.
+func (ss *Session) ProfileStartup(st *Startup) (*StartupReport, error) {
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer r.Close()
+
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer null.Close()
+
+	extra := make([]*os.File, startupFD-2)
+	extra[startupFD-3] = w
+	cmd := &exec.Cmd{
+		Path:       ss.Shell.Exec,
+		Args:       ss.Args,
+		Env:        ss.Env,
+		Dir:        ss.Dir,
+		Stdin:      null,
+		Stdout:     os.Stderr,
+		Stderr:     os.Stderr,
+		ExtraFiles: extra,
+	}
+
+	type mark struct {
+		index int
+		at    time.Time
+	}
+	var marks []mark
+	read := make(chan struct{})
+	go func() {
+		scan := bufio.NewScanner(r)
+		for scan.Scan() {
+			if i, err := strconv.Atoi(strings.TrimSpace(scan.Text())); err == nil {
+				marks = append(marks, mark{index: i, at: time.Now()})
+			}
+		}
+		close(read)
+	}()
+
+	start := time.Now()
+	err = cmd.Start()
+	w.Close() // only the shell's copy remains open
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	runErr := cmd.Wait()
+	end := time.Now()
+	status, exited := ExitStatus(runErr)
+	if !exited {
+		return nil, errors.Trace(runErr)
+	}
+	// background processes started by the shell may have inherited the pipe, so
+	// do not wait for it to be closed once all markers have been read.
+	r.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	<-read
+
+	rep := &StartupReport{Shell: ss.Shell.Exec, Total: end.Sub(start), Status: status}
+	if len(marks) == 0 {
+		return nil, errors.Errorf("no includes were sourced (is the goshrc file read by %q?)", ss.Shell.Exec)
+	}
+	rep.Init = marks[0].at.Sub(start)
+	rep.Exit = end.Sub(marks[len(marks)-1].at)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	prof := map[string]*StartupProfile{}
+	for i, m := range marks {
+		if m.index < 0 || m.index >= len(st.label) {
+			continue
+		}
+		lab := st.label[m.index]
+		p, ok := prof[lab.profile]
+		if !ok {
+			p = &StartupProfile{Name: lab.profile, Start: m.at.Sub(start)}
+			prof[lab.profile] = p
+			rep.Profiles = append(rep.Profiles, StartupProfile{Name: lab.profile})
+		}
+		if lab.file == "" || i+1 == len(marks) {
+			p.Duration = m.at.Sub(start) - p.Start
+			continue
+		}
+		p.Includes++
+		rep.Includes = append(rep.Includes, StartupInclude{
+			Profile:  lab.profile,
+			File:     lab.file,
+			Start:    m.at.Sub(start),
+			Duration: marks[i+1].at.Sub(m.at),
+		})
+	}
+	for i := range rep.Profiles {
+		rep.Profiles[i] = *prof[rep.Profiles[i].Name]
+	}
+	sort.SliceStable(rep.Includes, func(i, j int) bool {
+		return rep.Includes[i].Duration > rep.Includes[j].Duration
+	})
+	sort.SliceStable(rep.Profiles, func(i, j int) bool {
+		return rep.Profiles[i].Duration > rep.Profiles[j].Duration
+	})
+	return rep, nil
+}
+
+// WriteText writes the report as tables of includes and profiles, each sorted
+// by time spent in descending order.
+func (rep *StartupReport) WriteText(out io.Writer) error {
+	pct := func(d time.Duration) string {
+		if rep.Total <= 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", 100*float64(d)/float64(rep.Total))
+	}
+	ms := func(d time.Duration) string {
+		return fmt.Sprintf("%.3fms", float64(d)/float64(time.Millisecond))
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tPERCENT\tPROFILE\tINCLUDE")
+	for _, inc := range rep.Includes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", ms(inc.Duration), pct(inc.Duration), inc.Profile, inc.File)
+	}
+	fmt.Fprintln(tw, "\t\t\t")
+	fmt.Fprintln(tw, "TIME\tPERCENT\tPROFILE\tINCLUDES")
+	for _, pro := range rep.Profiles {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", ms(pro.Duration), pct(pro.Duration), pro.Name, pro.Includes)
+	}
+	fmt.Fprintln(tw, "\t\t\t")
+	fmt.Fprintf(tw, "%s\t%s\t(shell startup)\t\n", ms(rep.Init), pct(rep.Init))
+	fmt.Fprintf(tw, "%s\t%s\t(shell exit)\t\n", ms(rep.Exit), pct(rep.Exit))
+	fmt.Fprintf(tw, "%s\t%s\t(total)\t\n", ms(rep.Total), pct(rep.Total))
+	return errors.Trace(tw.Flush())
+}
+
+// WriteJSON writes the report as a JSON object, with all times in microseconds.
+func (rep *StartupReport) WriteJSON(out io.Writer) error {
+	us := func(d time.Duration) time.Duration { return d / time.Microsecond }
+	cp := *rep
+	cp.Total, cp.Init, cp.Exit = us(rep.Total), us(rep.Init), us(rep.Exit)
+	cp.Includes = append([]StartupInclude{}, rep.Includes...)
+	for i := range cp.Includes {
+		cp.Includes[i].Start, cp.Includes[i].Duration = us(cp.Includes[i].Start), us(cp.Includes[i].Duration)
+	}
+	cp.Profiles = append([]StartupProfile{}, rep.Profiles...)
+	for i := range cp.Profiles {
+		cp.Profiles[i].Start, cp.Profiles[i].Duration = us(cp.Profiles[i].Start), us(cp.Profiles[i].Duration)
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return errors.Trace(enc.Encode(&cp))
+}
+
+// WriteTrace writes the report in the Chrome trace event format, which can be
+// loaded by chrome://tracing or https://ui.perfetto.dev. Each include is nested
+// within its profile, which are nested within the shell process.
+func (rep *StartupReport) WriteTrace(out io.Writer) error {
+	type event struct {
+		Name string                 `json:"name"`
+		Cat  string                 `json:"cat"`
+		Ph   string                 `json:"ph"`
+		Ts   float64                `json:"ts"`
+		Dur  float64                `json:"dur"`
+		Pid  int                    `json:"pid"`
+		Tid  int                    `json:"tid"`
+		Args map[string]interface{} `json:"args,omitempty"`
+	}
+	us := func(d time.Duration) float64 { return float64(d) / float64(time.Microsecond) }
+	ev := []event{{
+		Name: rep.Shell, Cat: "shell", Ph: "X", Ts: 0, Dur: us(rep.Total), Pid: 1, Tid: 1,
+		Args: map[string]interface{}{"status": rep.Status},
+	}}
+	for _, pro := range rep.Profiles {
+		ev = append(ev, event{
+			Name: pro.Name, Cat: "profile", Ph: "X", Ts: us(pro.Start), Dur: us(pro.Duration), Pid: 1, Tid: 1,
+		})
+	}
+	for _, inc := range rep.Includes {
+		ev = append(ev, event{
+			Name: inc.File, Cat: "include", Ph: "X", Ts: us(inc.Start), Dur: us(inc.Duration), Pid: 1, Tid: 1,
+			Args: map[string]interface{}{"profile": inc.Profile},
+		})
+	}
+	enc := json.NewEncoder(out)
+	return errors.Trace(enc.Encode(map[string]interface{}{
+		"traceEvents":     ev,
+		"displayTimeUnit": "ms",
+	}))
+}
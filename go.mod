@@ -5,8 +5,11 @@ go 1.16
 require (
 	github.com/apex/log v1.9.0
 	github.com/ardnew/version v0.2.1
-	github.com/joho/godotenv v1.5.1 // indirect
+	github.com/creack/pty v1.1.18
+	github.com/joho/godotenv v1.5.1
 	github.com/juju/errors v0.0.0-20200330140219-3fe23663418f
 	github.com/juju/testing v0.0.0-20210302031854-2c7ee8570c07 // indirect
+	golang.org/x/sys v0.0.0-20201119102817-f84b799fce68
+	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )